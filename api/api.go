@@ -184,7 +184,7 @@ func (s *server) Submit(ctx context.Context, in *pb.SubmitRequest) (*pb.SubmitRe
 		UserName:       sql.NullString{String: name, Valid: name != ""},
 	}
 
-	id, err := database.SaveSubmission(s.db, submission)
+	id, err := database.SaveSubmissionWithStore(s.db, s.sourceStore, submission)
 	if err != nil {
 		log.Print(err)
 		return nil, errors.New("Submit failed")
@@ -222,16 +222,15 @@ func (s *server) SubmissionInfo(ctx context.Context, in *pb.SubmissionInfoReques
 	currentUserName := s.currentUserName(ctx)
 	currentUser, _ := database.FetchUserFromName(s.db, currentUserName)
 
-	var sub database.Submission
-	sub, err := database.FetchSubmission(s.db, in.Id)
+	sub, cases, err := database.FetchSubmissionWithResults(s.db, in.Id)
 	if err != nil {
 		log.Println("failed to fetch submission:", err)
 		return nil, errors.New("failed to fetch submission")
 	}
-	cases, err := database.FetchTestcaseResults(s.db, in.Id)
+	source, err := database.FetchSubmissionSource(s.db, s.sourceStore, in.Id)
 	if err != nil {
-		log.Println("failed to fetch submission results:", err)
-		return nil, errors.New("failed to fetch submission results")
+		log.Println("failed to fetch submission source:", err)
+		return nil, errors.New("failed to fetch submission source")
 	}
 
 	overview := toProtoSubmissionOverview(database.ToSubmissionOverView(sub))
@@ -243,15 +242,11 @@ func (s *server) SubmissionInfo(ctx context.Context, in *pb.SubmissionInfoReques
 
 	res := &pb.SubmissionInfoResponse{
 		Overview:     &overview,
-		Source:       sub.Source,
+		Source:       source,
 		CompileError: sub.CompileError,
 		CanRejudge:   rej,
 	}
 
-	sort.Slice(cases, func(i, j int) bool {
-		return cases[i].Testcase < cases[j].Testcase
-	})
-
 	for _, c := range cases {
 		res.CaseResults = append(res.CaseResults, &pb.SubmissionCaseResult{
 			Case:       c.Testcase,
@@ -322,7 +317,7 @@ func (s *server) Rejudge(ctx context.Context, in *pb.RejudgeRequest) (*pb.Rejudg
 
 func (s *server) LangList(ctx context.Context, in *pb.LangListRequest) (*pb.LangListResponse, error) {
 	var pbLangs []*pb.Lang
-	for _, lang := range langs.LANGS {
+	for _, lang := range langs.Langs() {
 		pbLangs = append(pbLangs, &pb.Lang{
 			Id:      lang.ID,
 			Name:    lang.Name,
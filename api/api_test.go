@@ -254,7 +254,7 @@ func createTestAPIClientWithSetup(t *testing.T, setUp func(db *gorm.DB, authClie
 		tokenToUID: map[string]string{},
 	}
 
-	s := NewGRPCServer(db, authClient)
+	s := NewGRPCServer(db, authClient, nil)
 	go func() {
 		if err := s.Serve(listen); err != nil {
 			log.Fatal("Server exited: ", err)
@@ -12,6 +12,7 @@ import (
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	pb "github.com/yosupo06/library-checker-judge/api/proto"
 	"github.com/yosupo06/library-checker-judge/database"
+	"github.com/yosupo06/library-checker-judge/storage"
 	"google.golang.org/grpc"
 
 	_ "github.com/lib/pq"
@@ -28,17 +29,20 @@ func getEnv(key, defaultValue string) string {
 
 type server struct {
 	pb.UnimplementedLibraryCheckerServiceServer
-	db         *gorm.DB
-	authClient AuthClient
+	db          *gorm.DB
+	authClient  AuthClient
+	sourceStore database.SourceStore
 }
 
-func NewGRPCServer(db *gorm.DB, authClient AuthClient) *grpc.Server {
+// NewGRPCServer creates the server, sources are saved inline if sourceStore is nil
+func NewGRPCServer(db *gorm.DB, authClient AuthClient, sourceStore database.SourceStore) *grpc.Server {
 	// launch gRPC server
 	s := grpc.NewServer()
 
 	pb.RegisterLibraryCheckerServiceServer(s, &server{
-		db:         db,
-		authClient: authClient,
+		db:          db,
+		authClient:  authClient,
+		sourceStore: sourceStore,
 	})
 
 	return s
@@ -69,10 +73,20 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// connect storage if sources are saved to it, otherwise they are saved inline
+	var sourceStore database.SourceStore
+	if getEnv("API_SOURCE_STORE", "") != "" {
+		storageClient, err := storage.Connect(storage.GetConfigFromEnv())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		sourceStore = storage.NewSourceStore(storageClient)
+	}
+
 	// launch api service
 	port := getEnv("PORT", "12380")
 	slog.Info("Launch gRPCWeb server", "port", port)
-	s := NewGRPCServer(db, firebaseAuth)
+	s := NewGRPCServer(db, firebaseAuth, sourceStore)
 	wrappedGrpc := grpcweb.WrapServer(s, grpcweb.WithOriginFunc(func(origin string) bool { return true }))
 	http.HandleFunc("/health", func(resp http.ResponseWriter, req *http.Request) {
 		io.WriteString(resp, "SERVING")
@@ -164,6 +164,9 @@ func AutoMigrate(db *gorm.DB) error {
 	if err := db.AutoMigrate(SubmissionTestcaseResult{}); err != nil {
 		return err
 	}
+	if err := migrateTestcaseResultsConstraint(db); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(Hack{}); err != nil {
 		return err
 	}
@@ -175,3 +178,18 @@ func AutoMigrate(db *gorm.DB) error {
 	}
 	return nil
 }
+
+// AutoMigrate doesn't create the constraint of has-many relationship, so create it manually.
+// Orphaned results are removed before that because they violate the constraint.
+func migrateTestcaseResultsConstraint(db *gorm.DB) error {
+	if db.Migrator().HasConstraint(&Submission{}, "TestcaseResults") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("submission NOT IN (?)", tx.Model(&Submission{}).Select("id")).
+			Delete(&SubmissionTestcaseResult{}).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().CreateConstraint(&Submission{}, "TestcaseResults")
+	})
+}
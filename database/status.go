@@ -0,0 +1,108 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type statusKind int
+
+const (
+	statusInvalid statusKind = iota
+	// WJ, the empty status is the zero value of Submission.Status
+	statusWaiting
+	// -, Fetching, Compiling and the progress like 3/10
+	statusJudging
+	// the verdicts
+	statusFinished
+	// IE
+	statusInternalError
+)
+
+// FINISHED_STATUSES are the verdicts of submissions except for "RE (signal N)" and IE
+var FINISHED_STATUSES = []string{"AC", "WA", "PE", "TLE", "MLE", "RE", "ITLE", "Fail", "Unknown", "CE", "ICE"}
+
+var (
+	progressStatusRegex = regexp.MustCompile(`^[0-9]+/[0-9]+$`)
+	signalStatusRegex   = regexp.MustCompile(`^RE \(signal [0-9]+\)$`)
+)
+
+// statusTransitions are the kinds of statuses a submission can be changed to from each kind.
+// A status can always be updated to itself, and RejudgeSubmission can reset any status.
+// Every status can be changed to IE, the judge may fail even after the verdict is saved.
+var statusTransitions = map[statusKind][]statusKind{
+	statusWaiting:       {statusJudging, statusInternalError},
+	statusJudging:       {statusWaiting, statusJudging, statusFinished, statusInternalError},
+	statusFinished:      {statusInternalError},
+	statusInternalError: {},
+}
+
+var (
+	ErrInvalidStatus           = errors.New("invalid status")
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+)
+
+func statusKindOf(status string) statusKind {
+	switch {
+	case status == "" || status == "WJ":
+		return statusWaiting
+	case status == "-" || status == "Fetching" || status == "Compiling" || progressStatusRegex.MatchString(status):
+		return statusJudging
+	case slices.Contains(FINISHED_STATUSES, status) || signalStatusRegex.MatchString(status):
+		return statusFinished
+	case status == "IE":
+		return statusInternalError
+	}
+	return statusInvalid
+}
+
+// IsJudgingStatus returns whether status is the status while judging, e.g. Compiling and 3/10
+func IsJudgingStatus(status string) bool {
+	return statusKindOf(status) == statusJudging
+}
+
+// ValidateStatus returns ErrInvalidStatus if status is not a status of submissions, the empty status is only valid as the initial one
+func ValidateStatus(status string) error {
+	if status == "" || statusKindOf(status) == statusInvalid {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+	return nil
+}
+
+// ValidateStatusTransition returns ErrInvalidStatusTransition if a submission can't be changed from the status from to to without a rejudge
+func ValidateStatusTransition(from, to string) error {
+	// not a transition, e.g. updates of other columns
+	if from == to {
+		return nil
+	}
+	if err := ValidateStatus(to); err != nil {
+		return err
+	}
+	if !slices.Contains(statusTransitions[statusKindOf(from)], statusKindOf(to)) {
+		return fmt.Errorf("%w: %q -> %q", ErrInvalidStatusTransition, from, to)
+	}
+	return nil
+}
+
+// lockSubmissionStatus locks the submission until the end of tx and returns its status.
+// ErrNotExist is returned if it is not found, ErrSubmissionConflict if its version is not version.
+func lockSubmissionStatus(tx *gorm.DB, id int32, version int32) (string, error) {
+	sub := Submission{}
+	if err := tx.Select("id", "status", "version").
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).
+		Take(&sub).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotExist
+	} else if err != nil {
+		return "", err
+	}
+	if sub.Version != version {
+		return "", ErrSubmissionConflict
+	}
+	return sub.Status, nil
+}
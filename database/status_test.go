@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateStatusTransition(t *testing.T) {
+	for _, test := range []struct {
+		from, to string
+		expected error
+	}{
+		{"WJ", "-", nil},
+		{"", "IE", nil},
+		{"-", "Fetching", nil},
+		{"Compiling", "3/10", nil},
+		{"3/10", "4/10", nil},
+		{"3/10", "AC", nil},
+		{"Compiling", "CE", nil},
+		{"10/10", "RE (signal 11)", nil},
+		{"3/10", "WJ", nil},
+		{"3/10", "IE", nil},
+		{"AC", "AC", nil},
+		{"AC", "IE", nil},
+		{"RE (signal 11)", "IE", nil},
+		{"IE", "IE", nil},
+		{"AC", "WJ", ErrInvalidStatusTransition},
+		{"AC", "WA", ErrInvalidStatusTransition},
+		{"WJ", "AC", ErrInvalidStatusTransition},
+		{"IE", "1/10", ErrInvalidStatusTransition},
+		{"-", "Acc", ErrInvalidStatus},
+		{"-", "", ErrInvalidStatus},
+		{"-", "RE (signal x)", ErrInvalidStatus},
+	} {
+		if err := ValidateStatusTransition(test.from, test.to); !errors.Is(err, test.expected) {
+			t.Fatal("Error ValidateStatusTransition", test.from, test.to, err)
+		}
+	}
+}
+
+func TestIsJudgingStatus(t *testing.T) {
+	for status, expected := range map[string]bool{
+		"-":         true,
+		"Compiling": true,
+		"3/10":      true,
+		"WJ":        false,
+		"AC":        false,
+		"IE":        false,
+		"3/":        false,
+	} {
+		if IsJudgingStatus(status) != expected {
+			t.Fatal("Error IsJudgingStatus", status)
+		}
+	}
+}
+
+func TestUpdateSubmissionStatusTransition(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Status:      "AC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateSubmissionStatus(db, id, 0, "WJ"); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Fatal("invalid transition is not rejected: ", err)
+	}
+	sub, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Status = "WA"
+	if err := UpdateSubmission(db, sub); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Fatal("invalid transition is not rejected: ", err)
+	}
+	if err := RejudgeSubmission(db, id, 0, "Acc"); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatal("invalid status is not rejected: ", err)
+	}
+
+	// rejudge resets the status
+	if err := RejudgeSubmission(db, id, 0, "WJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSubmissionStatus(db, id, 1, "-"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSubmissionStatus(db, id+1, 0, "-"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
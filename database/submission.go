@@ -1,12 +1,18 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"sort"
+	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Submission is db table
@@ -18,8 +24,12 @@ type Submission struct {
 	Lang             string
 	Status           string
 	PrevStatus       string
+	RejudgeCount     int32
+	Version          int32 // incremented by each update, see UpdateSubmission
 	Hacked           bool
-	Source           string
+	Source           string // not loaded by FetchSubmission, see FetchSubmissionSource
+	SourceKey        string // key of the source in SourceStore, Source is empty if set
+	SourceHash       string `gorm:"index"` // sha256 of the source, see FetchIdenticalSubmissions
 	TestCasesVersion string
 	MaxTime          int32
 	MaxMemory        int64
@@ -27,6 +37,10 @@ type Submission struct {
 	UserName         sql.NullString
 	User             User `gorm:"foreignKey:UserName"`
 	JudgedTime       time.Time
+	JudgedBy         string
+	ContestID        sql.NullInt32              `gorm:"index"` // null for practice submissions
+	DeletedAt        gorm.DeletedAt             `gorm:"index"`
+	TestcaseResults  []SubmissionTestcaseResult `gorm:"foreignKey:Submission;constraint:OnDelete:CASCADE"`
 }
 
 // SubmissionOverview is smart select table
@@ -42,6 +56,7 @@ type SubmissionOverView struct {
 	MaxMemory        int64
 	UserName         sql.NullString
 	User             User
+	ContestID        sql.NullInt32
 }
 
 func ToSubmissionOverView(s Submission) SubmissionOverView {
@@ -57,6 +72,7 @@ func ToSubmissionOverView(s Submission) SubmissionOverView {
 		MaxMemory:        s.MaxMemory,
 		UserName:         s.UserName,
 		User:             s.User,
+		ContestID:        s.ContestID,
 	}
 }
 
@@ -65,11 +81,15 @@ type SubmissionOrder int
 const (
 	ID_DESC SubmissionOrder = iota
 	MAX_TIME_ASC
+	MAX_TIME_DESC
+	MAX_MEMORY_ASC
+	MAX_MEMORY_DESC
+	SUBMISSION_TIME_DESC
 )
 
 // SubmissionTestcaseResult is db table
 type SubmissionTestcaseResult struct {
-	Submission int32  `gorm:"primaryKey"` // TODO: should be foreign key
+	Submission int32  `gorm:"primaryKey"` // foreign key of Submission.TestcaseResults
 	Testcase   string `gorm:"primaryKey"`
 	Status     string
 	Time       int32
@@ -78,11 +98,13 @@ type SubmissionTestcaseResult struct {
 	CheckerOut []byte
 }
 
+// FetchSubmission returns the submission without Source, use FetchSubmissionSource for it
 func FetchSubmission(db *gorm.DB, id int32) (Submission, error) {
 	sub := Submission{
 		ID: id,
 	}
 	if err := db.
+		Omit("source").
 		Preload("User").
 		Preload("Problem").
 		Take(&sub).Error; errors.Is(err, gorm.ErrRecordNotFound) {
@@ -94,39 +116,223 @@ func FetchSubmission(db *gorm.DB, id int32) (Submission, error) {
 	return sub, nil
 }
 
+// MaxSourceSize is the max size of sources in bytes saved by SaveSubmission and SaveSubmissionWithStore, 0 means unlimited
+var MaxSourceSize = 1024 * 1024
+
+var ErrSourceTooLarge = errors.New("source is too large")
+
+func checkSourceSize(source string) error {
+	if MaxSourceSize != 0 && len(source) > MaxSourceSize {
+		return fmt.Errorf("%w: %d bytes > %d bytes", ErrSourceTooLarge, len(source), MaxSourceSize)
+	}
+	return nil
+}
+
 // save submission and return id
 func SaveSubmission(db *gorm.DB, submission Submission) (int32, error) {
 	if submission.ID != 0 {
 		return 0, errors.New("must not specify submission id")
 	}
-	if err := db.Save(&submission).Error; err != nil {
+	if err := checkSourceSize(submission.Source); err != nil {
+		return 0, err
+	}
+	// the hash is already set by SaveSubmissionWithStore if the source is saved to the store
+	if submission.SourceHash == "" {
+		submission.SourceHash = sourceHash(submission.Source)
+	}
+	if err := db.Create(&submission).Error; err != nil {
 		return 0, err
 	}
 
 	return submission.ID, nil
 }
 
+// SourceStore stores sources of submissions outside of the submissions table
+type SourceStore interface {
+	// Save stores the source of the submission id and returns its key
+	Save(id int32, source string) (string, error)
+	Load(key string) (string, error)
+	Delete(key string) error
+}
+
+// SaveSubmissionWithStore saves the submission like SaveSubmission, but its source is saved to store.
+// The source is saved inline if store is nil.
+// The stored source is deleted again if the transaction is rolled back, so no orphan is left in store.
+func SaveSubmissionWithStore(db *gorm.DB, store SourceStore, submission Submission) (int32, error) {
+	if store == nil {
+		return SaveSubmission(db, submission)
+	}
+	if err := checkSourceSize(submission.Source); err != nil {
+		return 0, err
+	}
+	source := submission.Source
+	submission.Source = ""
+	submission.SourceHash = sourceHash(source)
+	id := int32(0)
+	key := ""
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if id, err = SaveSubmission(tx, submission); err != nil {
+			return err
+		}
+		if key, err = store.Save(id, source); err != nil {
+			return err
+		}
+		return tx.Model(&Submission{ID: id}).Update("source_key", key).Error
+	}); err != nil {
+		if key != "" {
+			if err2 := store.Delete(key); err2 != nil {
+				return 0, errors.Join(err, err2)
+			}
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// FetchSubmissionSource returns the source of the submission, store can be nil if all sources are inline
+func FetchSubmissionSource(db *gorm.DB, store SourceStore, id int32) (string, error) {
+	sub := Submission{
+		ID: id,
+	}
+	if err := db.Select("id", "source", "source_key").Take(&sub).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotExist
+	} else if err != nil {
+		return "", err
+	}
+	if sub.SourceKey == "" {
+		return sub.Source, nil
+	}
+	if store == nil {
+		return "", errors.New("source store is not available")
+	}
+	return store.Load(sub.SourceKey)
+}
+
+var ErrSubmissionConflict = errors.New("submission is modified concurrently")
+
+// UpdateSubmission overwrites the whole submission except for the source.
+// It fails with ErrSubmissionConflict if the submission is updated after submission.Version was fetched,
+// so callers must increment their Version after each successful update.
+// The status must be valid for ValidateStatusTransition from the current status.
 func UpdateSubmission(db *gorm.DB, submission Submission) error {
 	if submission.ID == 0 {
 		return errors.New("must specify submission id")
 	}
-	if err := db.Save(&submission).Error; err != nil {
-		return err
-	}
+	version := submission.Version
+	submission.Version++
+	return db.Transaction(func(tx *gorm.DB) error {
+		current, err := lockSubmissionStatus(tx, submission.ID, version)
+		if err != nil {
+			return err
+		}
+		if err := ValidateStatusTransition(current, submission.Status); err != nil {
+			return err
+		}
+		result := tx.Model(&Submission{}).
+			Where("id = ? AND version = ?", submission.ID, version).
+			Select("*").Omit(clause.Associations, "deleted_at", "source", "source_key", "source_hash").
+			Updates(&submission)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSubmissionConflict
+		}
+		return nil
+	})
+}
 
-	return nil
+// UpdateSubmissionStatus updates only the status, it must be valid for ValidateStatusTransition from the current status.
+// Like UpdateSubmission, it fails with ErrSubmissionConflict if the version of the submission is not version.
+func UpdateSubmissionStatus(db *gorm.DB, id int32, version int32, status string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		current, err := lockSubmissionStatus(tx, id, version)
+		if err != nil {
+			return err
+		}
+		if err := ValidateStatusTransition(current, status); err != nil {
+			return err
+		}
+		return updateSubmissionColumns(tx, id, version, map[string]interface{}{
+			"status": status,
+		})
+	})
+}
+
+// UpdateSubmissionResult updates only the result columns of the submission.
+// The status must be valid for ValidateStatusTransition from the current status.
+// Like UpdateSubmission, it fails with ErrSubmissionConflict if the version of the submission is not version.
+func UpdateSubmissionResult(db *gorm.DB, id int32, version int32, status string, maxTime int32, maxMemory int64, judgedTime time.Time, judgedBy string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		current, err := lockSubmissionStatus(tx, id, version)
+		if err != nil {
+			return err
+		}
+		if err := ValidateStatusTransition(current, status); err != nil {
+			return err
+		}
+		return updateSubmissionColumns(tx, id, version, map[string]interface{}{
+			"status":      status,
+			"max_time":    maxTime,
+			"max_memory":  maxMemory,
+			"judged_time": judgedTime,
+			"judged_by":   judgedBy,
+		})
+	})
 }
 
-func UpdateSubmissionStatus(db *gorm.DB, id int32, status string) error {
-	if err := db.Updates(Submission{
-		ID:     id,
-		Status: status,
-	}).Error; err != nil {
+// RejudgeSubmission moves Status into PrevStatus, sets the new status and increments RejudgeCount atomically.
+// It is the explicit reset of the status, the transition is not checked but status must be valid.
+// Like UpdateSubmission, it fails with ErrSubmissionConflict if the version of the submission is not version.
+func RejudgeSubmission(db *gorm.DB, id int32, version int32, status string) error {
+	if err := ValidateStatus(status); err != nil {
 		return err
 	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockSubmissionStatus(tx, id, version); err != nil {
+			return err
+		}
+		return updateSubmissionColumns(tx, id, version, map[string]interface{}{
+			"prev_status":   gorm.Expr("status"),
+			"status":        status,
+			"rejudge_count": gorm.Expr("rejudge_count + 1"),
+		})
+	})
+}
+
+// updateSubmissionColumns updates columns and increments the version only if the version of the submission is version
+func updateSubmissionColumns(tx *gorm.DB, id int32, version int32, columns map[string]interface{}) error {
+	columns["version"] = gorm.Expr("version + 1")
+	result := tx.Model(&Submission{}).Where("id = ? AND version = ?", id, version).Updates(columns)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSubmissionConflict
+	}
 	return nil
 }
 
+// BeginRejudge runs RejudgeSubmission and ClearTestcaseResult in one transaction, only if holder still holds the task.
+// It returns ErrTaskNotHeld if the task is expired or taken by another holder.
+func BeginRejudge(db *gorm.DB, id int32, version int32, status string, taskID int32, holder string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		task := Task{}
+		if err := tx.Where("id = ? AND holder = ? AND available > ?", taskID, holder, time.Now()).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Take(&task).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTaskNotHeld
+		} else if err != nil {
+			return err
+		}
+		if err := RejudgeSubmission(tx, id, version, status); err != nil {
+			return err
+		}
+		return ClearTestcaseResult(tx, id)
+	})
+}
+
 func ClearTestcaseResult(db *gorm.DB, subID int32) error {
 	if err := db.Where("submission = ?", subID).Delete(&SubmissionTestcaseResult{}).Error; err != nil {
 		return err
@@ -142,33 +348,173 @@ func SaveTestcaseResult(db *gorm.DB, result SubmissionTestcaseResult) error {
 	return nil
 }
 
+// SaveTestcaseResults saves results in one query, existing results are overwritten
+func SaveTestcaseResults(db *gorm.DB, results []SubmissionTestcaseResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	if err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "submission"}, {Name: "testcase"}},
+		UpdateAll: true,
+	}).Create(&results).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// testcaseNaturalOrder sorts testcases by the name without the trailing number, then by the number.
+// e.g. example_00, example_01, random_2, random_10, random_10.in, random_100
+const testcaseNaturalOrder = `regexp_replace(testcase, '[0-9]+(\.[^.0-9]*)?$', ''), ` +
+	`substring(testcase from '([0-9]+)(\.[^.0-9]*)?$')::numeric NULLS FIRST, ` +
+	`testcase`
+
 func FetchTestcaseResults(db *gorm.DB, id int32) ([]SubmissionTestcaseResult, error) {
 	var cases []SubmissionTestcaseResult
-	if err := db.Where("submission = ?", id).Find(&cases).Error; err != nil {
+	if err := db.Where("submission IN (?)", db.Model(&Submission{}).Select("id").Where("id = ?", id)).
+		Order(testcaseNaturalOrder).
+		Find(&cases).Error; err != nil {
 		return nil, err
 	}
 
-	// TODO: to DB query
-	sort.Slice(cases, func(i, j int) bool {
-		return cases[i].Testcase < cases[j].Testcase
-	})
-
 	return cases, nil
 }
 
+// FetchSubmissionWithResults fetches the submission and its testcase results in the natural order of testcases.
+// They are fetched in one repeatable read transaction, so the results are not cleared by a rejudge between them.
+// ErrNotExist is returned if the submission doesn't exist, same as FetchSubmission.
+func FetchSubmissionWithResults(db *gorm.DB, id int32) (Submission, []SubmissionTestcaseResult, error) {
+	var sub Submission
+	var cases []SubmissionTestcaseResult
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if sub, err = FetchSubmission(tx, id); err != nil {
+			return err
+		}
+		if cases, err = FetchTestcaseResults(tx, id); err != nil {
+			return err
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}); err != nil {
+		return Submission{}, nil, err
+	}
+	return sub, cases, nil
+}
+
+// orderKey returns the column and direction of o
+func orderKey(o SubmissionOrder) (column string, desc bool, ok bool) {
+	switch o {
+	case ID_DESC:
+		return "id", true, true
+	case MAX_TIME_ASC:
+		return "max_time", false, true
+	case MAX_TIME_DESC:
+		return "max_time", true, true
+	case MAX_MEMORY_ASC:
+		return "max_memory", false, true
+	case MAX_MEMORY_DESC:
+		return "max_memory", true, true
+	case SUBMISSION_TIME_DESC:
+		return "submission_time", true, true
+	}
+	return "", false, false
+}
+
 func applyOrder(query *gorm.DB, order []SubmissionOrder) *gorm.DB {
 	for _, o := range order {
-		switch o {
-		case ID_DESC:
-			query.Order("id desc")
-		case MAX_TIME_ASC:
-			query.Order("max_time asc")
+		column, desc, ok := orderKey(o)
+		if !ok {
+			continue
+		}
+		if desc {
+			query.Order(column + " desc")
+		} else {
+			query.Order(column + " asc")
 		}
 	}
 	return query
 }
 
-func FetchSubmissionList(db *gorm.DB, problem, status, lang, user string, dedupUser bool, order []SubmissionOrder, offset, limit int) ([]SubmissionOverView, int64, error) {
+// SubmissionListOption is an additional filter of the submission list
+type SubmissionListOption func(query *gorm.DB) *gorm.DB
+
+// WithSubmissionTimeRange filters submissions by SubmissionTime (inclusive).
+// Zero value of from / to means unbounded.
+func WithSubmissionTimeRange(from, to time.Time) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		if !from.IsZero() && !to.IsZero() {
+			return query.Where("submission_time BETWEEN ? AND ?", from, to)
+		}
+		if !from.IsZero() {
+			return query.Where("submission_time >= ?", from)
+		}
+		if !to.IsZero() {
+			return query.Where("submission_time <= ?", to)
+		}
+		return query
+	}
+}
+
+// WithMaxTimeRange filters submissions by MaxTime in milliseconds (inclusive), nil bound means no bound.
+// Note that MaxTime of not judged submissions is -1.
+func WithMaxTimeRange(minMs, maxMs *int32) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		if minMs != nil {
+			query = query.Where("max_time >= ?", *minMs)
+		}
+		if maxMs != nil {
+			query = query.Where("max_time <= ?", *maxMs)
+		}
+		return query
+	}
+}
+
+// WithMaxMemoryRange filters submissions by MaxMemory in bytes (inclusive), nil bound means no bound.
+// Note that MaxMemory of not judged submissions is -1.
+func WithMaxMemoryRange(minBytes, maxBytes *int64) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		if minBytes != nil {
+			query = query.Where("max_memory >= ?", *minBytes)
+		}
+		if maxBytes != nil {
+			query = query.Where("max_memory <= ?", *maxBytes)
+		}
+		return query
+	}
+}
+
+// WithStatuses filters submissions whose status is one of statuses, empty statuses means no filter
+func WithStatuses(statuses ...string) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		if len(statuses) == 0 {
+			return query
+		}
+		return query.Where("status IN ?", statuses)
+	}
+}
+
+// WithHacked filters submissions by whether they are hacked
+func WithHacked(hacked bool) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		return query.Where("hacked = ?", hacked)
+	}
+}
+
+// WithJudgedBy filters submissions judged by the judge server
+func WithJudgedBy(judgeName string) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		return query.Where("judged_by = ?", judgeName)
+	}
+}
+
+// WithContest filters submissions of the contest
+func WithContest(contestID int32) SubmissionListOption {
+	return func(query *gorm.DB) *gorm.DB {
+		return query.Where("contest_id = ?", contestID)
+	}
+}
+
+func filterSubmissions(db *gorm.DB, problem, status, lang, user string, options []SubmissionListOption) *gorm.DB {
 	filter := &Submission{
 		ProblemName: problem,
 		Status:      status,
@@ -177,6 +523,14 @@ func FetchSubmissionList(db *gorm.DB, problem, status, lang, user string, dedupU
 	}
 
 	query := db.Model(&Submission{}).Where(filter)
+	for _, option := range options {
+		query = option(query)
+	}
+	return query
+}
+
+func FetchSubmissionList(db *gorm.DB, problem, status, lang, user string, dedupUser bool, order []SubmissionOrder, offset, limit int, options ...SubmissionListOption) ([]SubmissionOverView, int64, error) {
+	query := filterSubmissions(db, problem, status, lang, user, options)
 	query.Session(&gorm.Session{})
 
 	if dedupUser {
@@ -201,3 +555,440 @@ func FetchSubmissionList(db *gorm.DB, problem, status, lang, user string, dedupU
 
 	return submissions, count, nil
 }
+
+// submissionCursor is the position of the last fetched submission
+type submissionCursor struct {
+	ID             int32     `json:"id"`
+	MaxTime        int32     `json:"max_time"`
+	MaxMemory      int64     `json:"max_memory"`
+	SubmissionTime time.Time `json:"submission_time"`
+}
+
+func (c submissionCursor) value(column string) interface{} {
+	switch column {
+	case "max_time":
+		return c.MaxTime
+	case "max_memory":
+		return c.MaxMemory
+	case "submission_time":
+		return c.SubmissionTime
+	}
+	return c.ID
+}
+
+func encodeSubmissionCursor(s SubmissionOverView) (string, error) {
+	data, err := json.Marshal(submissionCursor{
+		ID:             s.ID,
+		MaxTime:        s.MaxTime,
+		MaxMemory:      s.MaxMemory,
+		SubmissionTime: s.SubmissionTime,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeSubmissionCursor(cursor string) (submissionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return submissionCursor{}, errors.New("invalid cursor")
+	}
+	var c submissionCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return submissionCursor{}, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// FetchSubmissionListByCursor is keyset pagination version of FetchSubmissionList.
+// cursor is "" for the first page, and the returned cursor is "" if there are no more submissions.
+func FetchSubmissionListByCursor(db *gorm.DB, problem, status, lang, user string, order []SubmissionOrder, cursor string, limit int, options ...SubmissionListOption) ([]SubmissionOverView, string, error) {
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be positive")
+	}
+
+	// id is unique, so (keys..., id) is a total order
+	keys := []SubmissionOrder{}
+	for _, o := range order {
+		if _, _, ok := orderKey(o); ok {
+			keys = append(keys, o)
+		}
+		if o == ID_DESC {
+			break
+		}
+	}
+	if len(keys) == 0 || keys[len(keys)-1] != ID_DESC {
+		keys = append(keys, ID_DESC)
+	}
+
+	query := filterSubmissions(db, problem, status, lang, user, options)
+
+	if cursor != "" {
+		c, err := decodeSubmissionCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+		conds := []string{}
+		args := []interface{}{}
+		for i, o := range keys {
+			cond := ""
+			for _, prev := range keys[:i] {
+				column, _, _ := orderKey(prev)
+				cond += column + " = ? AND "
+				args = append(args, c.value(column))
+			}
+			column, desc, _ := orderKey(o)
+			if desc {
+				cond += column + " < ?"
+			} else {
+				cond += column + " > ?"
+			}
+			args = append(args, c.value(column))
+			conds = append(conds, "("+cond+")")
+		}
+		query = query.Where(strings.Join(conds, " OR "), args...)
+	}
+
+	query = applyOrder(query, keys)
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := query.Limit(limit + 1).
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, "", err
+	}
+
+	if len(submissions) <= limit {
+		return submissions, "", nil
+	}
+	submissions = submissions[:limit]
+	next, err := encodeSubmissionCursor(submissions[limit-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return submissions, next, nil
+}
+
+// CountSubmissionsByStatus returns the number of submissions for each status.
+// Empty problem / user means no filter.
+func CountSubmissionsByStatus(db *gorm.DB, problem, user string, options ...SubmissionListOption) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := filterSubmissions(db, problem, "", "", user, options).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+const MAX_THROUGHPUT_BUCKETS = 10000
+
+type ThroughputBucket struct {
+	Start time.Time
+	Count int64
+}
+
+// SubmissionThroughput returns the number of submissions in each bucket of [from, to), e.g. submissions per hour.
+// Buckets start at from, and buckets without submissions are also returned. Empty problem / user means no filter.
+func SubmissionThroughput(db *gorm.DB, problem, user string, from, to time.Time, bucket time.Duration, options ...SubmissionListOption) ([]ThroughputBucket, error) {
+	if bucket < time.Second {
+		return nil, fmt.Errorf("too small bucket: %v", bucket)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid range: %v - %v", from, to)
+	}
+	n := int((to.Sub(from) + bucket - 1) / bucket)
+	if n > MAX_THROUGHPUT_BUCKETS {
+		return nil, fmt.Errorf("too many buckets: %d", n)
+	}
+
+	var rows []struct {
+		Bucket int
+		Count  int64
+	}
+	if err := filterSubmissions(db, problem, "", "", user, options).
+		Where("submission_time >= ? AND submission_time < ?", from, to).
+		Select("FLOOR((EXTRACT(EPOCH FROM submission_time) - ?) / ?)::bigint AS bucket, COUNT(*) AS count",
+			float64(from.UnixNano())/1e9, bucket.Seconds()).
+		Group("bucket").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	buckets := make([]ThroughputBucket, n)
+	for i := range buckets {
+		buckets[i].Start = from.Add(time.Duration(i) * bucket)
+	}
+	for _, row := range rows {
+		if row.Bucket < 0 || n <= row.Bucket {
+			return nil, fmt.Errorf("invalid bucket: %d", row.Bucket)
+		}
+		buckets[row.Bucket].Count = row.Count
+	}
+	return buckets, nil
+}
+
+// FetchContestSubmissions returns all submissions of the contest submitted between start and end (inclusive) in the order of submission time.
+// Zero value of start / end means unbounded.
+func FetchContestSubmissions(db *gorm.DB, contestID int32, start, end time.Time) ([]SubmissionOverView, error) {
+	query := filterSubmissions(db, "", "", "", "", []SubmissionListOption{
+		WithContest(contestID),
+		WithSubmissionTimeRange(start, end),
+	})
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := query.
+		Order("submission_time asc, id asc").
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+
+	return submissions, nil
+}
+
+// FetchSubmissionsWithStaleTestcases returns submissions of the problem judged with test cases other than currentVersion, oldest first.
+// They should be rejudged after the test cases are updated.
+func FetchSubmissionsWithStaleTestcases(db *gorm.DB, problem, currentVersion string, offset, limit int) ([]SubmissionOverView, error) {
+	if problem == "" {
+		return nil, errors.New("empty problem name")
+	}
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := db.Model(&Submission{}).
+		Where("problem_name = ? AND test_cases_version <> ?", problem, currentVersion).
+		Order("id asc").
+		Limit(limit).Offset(offset).
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+func sourceHash(source string) string {
+	hash := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(hash[:])
+}
+
+// FetchIdenticalSubmissions returns the other submissions of the same problem with the same source as the submission id, ordered by id.
+// The submissions of the same user are excluded if otherUsersOnly. Submissions saved before SourceHash was added are not matched.
+func FetchIdenticalSubmissions(db *gorm.DB, id int32, otherUsersOnly bool, limit int) ([]SubmissionOverView, error) {
+	sub := Submission{ID: id}
+	if err := db.Select("id", "problem_name", "user_name", "source_hash").Take(&sub).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	var submissions = make([]SubmissionOverView, 0)
+	if sub.SourceHash == "" {
+		return submissions, nil
+	}
+	query := db.Model(&Submission{}).
+		Where("source_hash = ? AND problem_name = ? AND id <> ?", sub.SourceHash, sub.ProblemName, sub.ID)
+	if otherUsersOnly && sub.UserName.Valid {
+		query = query.Where("user_name IS DISTINCT FROM ?", sub.UserName.String)
+	}
+	if err := query.
+		Order("id asc").
+		Limit(limit).
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+// FetchUsedLanguages returns the distinct languages of the submissions for the problem in ascending order.
+// Only the submissions of user are used if user is not empty.
+func FetchUsedLanguages(db *gorm.DB, problem, user string) ([]string, error) {
+	if problem == "" {
+		return nil, errors.New("empty problem name")
+	}
+
+	langs := make([]string, 0)
+	if err := filterSubmissions(db, problem, "", "", user, nil).
+		Distinct("lang").
+		Order("lang asc").
+		Pluck("lang", &langs).Error; err != nil {
+		return nil, err
+	}
+	return langs, nil
+}
+
+// FetchFastestAcceptedSubmissions returns the fastest AC submission of each user for the problem.
+// Ties are broken by the earliest submission time. Anonymous submissions are ignored.
+func FetchFastestAcceptedSubmissions(db *gorm.DB, problem string) ([]SubmissionOverView, error) {
+	if problem == "" {
+		return nil, errors.New("empty problem name")
+	}
+
+	fastest := db.Model(&Submission{}).
+		Where("problem_name = ? AND status = ? AND user_name IS NOT NULL", problem, "AC").
+		Order("user_name, max_time asc, submission_time asc, id asc").
+		Select("DISTINCT ON (user_name) id")
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := db.Model(&Submission{}).
+		Where("id IN (?)", fastest).
+		Order("max_time asc, submission_time asc, id asc").
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+
+	return submissions, nil
+}
+
+// FetchBestSubmissionsForUser returns the best submission of the user for each problem, ordered by the problem name.
+// AC submissions take precedence; among them the fastest one (the earliest on ties) is chosen, otherwise the latest submission is chosen.
+// Empty problemNames means all problems.
+func FetchBestSubmissionsForUser(db *gorm.DB, userName string, problemNames []string) ([]SubmissionOverView, error) {
+	if userName == "" {
+		return nil, errors.New("empty user name")
+	}
+
+	best := db.Model(&Submission{}).Where("user_name = ?", userName)
+	if len(problemNames) != 0 {
+		best = best.Where("problem_name IN ?", problemNames)
+	}
+	best = best.
+		Order("problem_name, status <> 'AC'").
+		Order("CASE WHEN status = 'AC' THEN max_time END asc").
+		Order("CASE WHEN status = 'AC' THEN submission_time END asc").
+		Order("submission_time desc, id desc").
+		Select("DISTINCT ON (problem_name) id")
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := db.Model(&Submission{}).
+		Where("id IN (?)", best).
+		Order("problem_name asc").
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+
+	return submissions, nil
+}
+
+// CountAcceptedProblems returns the number of distinct problems the user has AC submissions on.
+// It is based on the current Status, so a submission counts only while it is still AC after rejudges.
+// Hacked submissions count until they are rejudged with the new test cases.
+func CountAcceptedProblems(db *gorm.DB, user string) (int64, error) {
+	if user == "" {
+		return 0, errors.New("empty user name")
+	}
+	count := int64(0)
+	if err := db.Model(&Submission{}).
+		Where("user_name = ? AND status = ?", user, "AC").
+		Distinct("problem_name").
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchAcceptedProblems returns the sorted names of problems the user has AC submissions on.
+// See CountAcceptedProblems for how rejudged / hacked submissions are treated.
+func FetchAcceptedProblems(db *gorm.DB, user string) ([]string, error) {
+	if user == "" {
+		return nil, errors.New("empty user name")
+	}
+	problems := make([]string, 0)
+	if err := db.Model(&Submission{}).
+		Where("user_name = ? AND status = ?", user, "AC").
+		Distinct().
+		Order("problem_name").
+		Pluck("problem_name", &problems).Error; err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// SearchSubmissionsBySource returns submissions whose source contains substr (case-insensitive), newest first.
+// Only developers can use it because it scans the whole table, it may be slow without a trigram index on source.
+// Sources saved to SourceStore (i.e. SourceKey is set) are not searched because they are not in the table.
+func SearchSubmissionsBySource(db *gorm.DB, requester User, substr string, offset, limit int) ([]SubmissionOverView, error) {
+	if !requester.IsDeveloper {
+		return nil, ErrPermissionDenied
+	}
+	if substr == "" {
+		return nil, errors.New("empty search string")
+	}
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(substr) + "%"
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := db.Model(&Submission{}).
+		Where("source ILIKE ?", pattern).
+		Order("id desc").
+		Limit(limit).Offset(offset).
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+// DeleteSubmission soft-deletes the submission, it can be restored by RestoreSubmission
+func DeleteSubmission(db *gorm.DB, requester User, id int32) error {
+	if !requester.IsDeveloper {
+		return ErrPermissionDenied
+	}
+	result := db.Delete(&Submission{ID: id})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// FetchDeletedSubmissions returns soft-deleted submissions, newest first
+func FetchDeletedSubmissions(db *gorm.DB, requester User, offset, limit int) ([]SubmissionOverView, error) {
+	if !requester.IsDeveloper {
+		return nil, ErrPermissionDenied
+	}
+
+	var submissions = make([]SubmissionOverView, 0)
+	if err := db.Unscoped().Model(&Submission{}).
+		Where("deleted_at IS NOT NULL").
+		Order("id desc").
+		Limit(limit).Offset(offset).
+		Preload("User").Preload("Problem").
+		Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+func RestoreSubmission(db *gorm.DB, requester User, id int32) error {
+	if !requester.IsDeveloper {
+		return ErrPermissionDenied
+	}
+	result := db.Unscoped().Model(&Submission{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
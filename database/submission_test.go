@@ -2,8 +2,12 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"reflect"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestSubmission(t *testing.T) {
@@ -46,6 +50,39 @@ func TestFetchInvalidSubmission(t *testing.T) {
 	}
 }
 
+func TestSaveTooLargeSource(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	oldSize := MaxSourceSize
+	MaxSourceSize = 10
+	t.Cleanup(func() { MaxSourceSize = oldSize })
+
+	if _, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Source:      "0123456789",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Source:      "0123456789a",
+	}); !errors.Is(err, ErrSourceTooLarge) {
+		t.Fatal("too large source is saved", err)
+	}
+	store := mapSourceStore{}
+	if _, err := SaveSubmissionWithStore(db, store, Submission{
+		ProblemName: "aplusb",
+		Source:      "0123456789a",
+	}); !errors.Is(err, ErrSourceTooLarge) {
+		t.Fatal("too large source is saved to store", err)
+	}
+	if len(store) != 0 {
+		t.Fatal("source is saved to store", store)
+	}
+}
+
 func TestUpdateSubmissionStatus(t *testing.T) {
 	db := CreateTestDB(t)
 
@@ -65,9 +102,12 @@ func TestUpdateSubmissionStatus(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := UpdateSubmissionStatus(db, id, "IE"); err != nil {
+	if err := UpdateSubmissionStatus(db, id, 0, "IE"); err != nil {
 		t.Fatal(err)
 	}
+	if err := UpdateSubmissionStatus(db, id, 0, "AC"); err != ErrSubmissionConflict {
+		t.Fatal("stale update is not rejected: ", err)
+	}
 
 	sub2, err := FetchSubmission(db, id)
 
@@ -80,6 +120,160 @@ func TestUpdateSubmissionStatus(t *testing.T) {
 	}
 }
 
+func TestUpdateSubmissionResult(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName:  "aplusb",
+		Lang:         "cpp",
+		Source:       "int main() {}",
+		CompileError: []byte("warning"),
+		Status:       "-",
+		MaxTime:      1234,
+		MaxMemory:    5678,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	judgedTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := UpdateSubmissionResult(db, id, 0, "AC", 0, 0, judgedTime, "judge1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSubmissionResult(db, id, 0, "WA", 0, 0, judgedTime, "judge2"); err != ErrSubmissionConflict {
+		t.Fatal("stale update is not rejected: ", err)
+	}
+
+	sub, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != "AC" || sub.MaxTime != 0 || sub.MaxMemory != 0 || !sub.JudgedTime.Equal(judgedTime) || sub.JudgedBy != "judge1" {
+		t.Fatal("result is not updated", sub)
+	}
+	source, err := FetchSubmissionSource(db, nil, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "int main() {}" || sub.Lang != "cpp" || string(sub.CompileError) != "warning" {
+		t.Fatal("other fields are clobbered", sub, source)
+	}
+
+	if err := UpdateSubmissionResult(db, id+1, 0, "AC", 0, 0, judgedTime, "judge1"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestRejudgeSubmission(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Status:      "AC",
+		MaxTime:     1234,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, status := range []string{"WA", "AC"} {
+		if err := RejudgeSubmission(db, id, int32(2*i), "-"); err != nil {
+			t.Fatal(err)
+		}
+		if err := UpdateSubmissionStatus(db, id, int32(2*i+1), status); err != nil {
+			t.Fatal(err)
+		}
+
+		sub, err := FetchSubmission(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub.RejudgeCount != int32(i+1) || sub.Status != status || sub.MaxTime != 1234 {
+			t.Fatal("invalid data", sub)
+		}
+	}
+
+	sub, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.PrevStatus != "WA" {
+		t.Fatal("PrevStatus is not WA: ", sub.PrevStatus)
+	}
+
+	if err := RejudgeSubmission(db, id, 0, "-"); err != ErrSubmissionConflict {
+		t.Fatal("stale rejudge is not rejected: ", err)
+	}
+	if err := RejudgeSubmission(db, id+1, 0, "-"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestBeginRejudge(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Status:      "AC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveTestcaseResult(db, SubmissionTestcaseResult{Submission: id, Testcase: "case1", Status: "AC"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PushSubmissionTask(db, id, 1); err != nil {
+		t.Fatal(err)
+	}
+	taskID, _, err := PopTask(db, "judge1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BeginRejudge(db, id, 0, "-", taskID, "judge2"); err != ErrTaskNotHeld {
+		t.Fatal("rejudge is begun by other holder: ", err)
+	}
+	sub, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != "AC" || sub.RejudgeCount != 0 {
+		t.Fatal("submission is modified", sub)
+	}
+
+	if err := BeginRejudge(db, id, 0, "-", taskID, "judge1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := BeginRejudge(db, id, 0, "-", taskID, "judge1"); err != ErrSubmissionConflict {
+		t.Fatal("stale rejudge is not rejected: ", err)
+	}
+	sub, err = FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != "-" || sub.PrevStatus != "AC" || sub.RejudgeCount != 1 {
+		t.Fatal("invalid data", sub)
+	}
+	results, err := FetchTestcaseResults(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatal("testcase results are not cleared", results)
+	}
+
+	// the transaction is rolled back if the submission doesn't exist
+	if err := BeginRejudge(db, id+1, 0, "-", taskID, "judge1"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
 func TestSubmissionResult(t *testing.T) {
 	db := CreateTestDB(t)
 
@@ -121,6 +315,48 @@ func TestSubmissionResult(t *testing.T) {
 	}
 }
 
+func TestFetchSubmissionWithResults(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Status:      "AC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"random_10", "example_00", "random_2"} {
+		if err := SaveTestcaseResult(db, SubmissionTestcaseResult{
+			Submission: id,
+			Testcase:   name,
+			Status:     "AC",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub, cases, err := FetchSubmissionWithResults(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.ID != id || sub.Status != "AC" || sub.Problem.Name != "aplusb" {
+		t.Fatal("Error submission", sub)
+	}
+	names := []string{}
+	for _, c := range cases {
+		names = append(names, c.Testcase)
+	}
+	if !reflect.DeepEqual(names, []string{"example_00", "random_2", "random_10"}) {
+		t.Fatal("Error testcases", names)
+	}
+
+	if _, _, err := FetchSubmissionWithResults(db, id+1); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
 func TestSubmissionResultEmpty(t *testing.T) {
 	db := CreateTestDB(t)
 
@@ -320,3 +556,1109 @@ func TestDedupSubmissionList(t *testing.T) {
 		}
 	}
 }
+
+func TestSubmissionListOrder(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	now := time.Now()
+	for _, sub := range []Submission{
+		{ProblemName: "aplusb", MaxTime: 100, MaxMemory: 30, SubmissionTime: now.Add(-2 * time.Hour)},
+		{ProblemName: "aplusb", MaxTime: 300, MaxMemory: 10, SubmissionTime: now},
+		{ProblemName: "aplusb", MaxTime: 300, MaxMemory: 20, SubmissionTime: now.Add(-time.Hour)},
+	} {
+		if _, err := SaveSubmission(db, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		order    []SubmissionOrder
+		expected []int32
+	}{
+		{order: []SubmissionOrder{MAX_TIME_DESC, ID_DESC}, expected: []int32{3, 2, 1}},
+		{order: []SubmissionOrder{MAX_MEMORY_ASC}, expected: []int32{2, 3, 1}},
+		{order: []SubmissionOrder{MAX_MEMORY_DESC}, expected: []int32{1, 3, 2}},
+		{order: []SubmissionOrder{SUBMISSION_TIME_DESC}, expected: []int32{2, 3, 1}},
+	} {
+		subs, count, err := FetchSubmissionList(db, "", "", "", "", false, tc.order, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatal("count is not 3: ", count)
+		}
+		ids := []int32{}
+		for _, sub := range subs {
+			ids = append(ids, sub.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Fatal("invalid order", tc.order, ids, "!=", tc.expected)
+		}
+	}
+}
+
+func TestSubmissionListByCursor(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, maxTime := range []int32{300, 100, 200, 100, 300} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			MaxTime:     maxTime,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		order    []SubmissionOrder
+		expected []int32
+	}{
+		{order: []SubmissionOrder{ID_DESC}, expected: []int32{5, 4, 3, 2, 1}},
+		{order: []SubmissionOrder{MAX_TIME_ASC, ID_DESC}, expected: []int32{4, 2, 3, 5, 1}},
+		{order: []SubmissionOrder{MAX_TIME_DESC}, expected: []int32{5, 1, 3, 4, 2}},
+	} {
+		ids := []int32{}
+		cursor := ""
+		for i := 0; ; i++ {
+			if i > 5 {
+				t.Fatal("too many pages")
+			}
+			subs, next, err := FetchSubmissionListByCursor(db, "", "", "", "", tc.order, cursor, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, sub := range subs {
+				ids = append(ids, sub.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Fatal("invalid order", tc.order, ids, "!=", tc.expected)
+		}
+	}
+
+	if _, _, err := FetchSubmissionListByCursor(db, "", "", "", "", nil, "invalid-cursor", 2); err == nil {
+		t.Fatal("invalid cursor is accepted")
+	}
+}
+
+func TestSubmissionListTimeRange(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName:    "aplusb",
+			SubmissionTime: base.Add(time.Duration(i) * time.Hour),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		from, to time.Time
+		expected int64
+	}{
+		{from: time.Time{}, to: time.Time{}, expected: 5},
+		{from: base.Add(time.Hour), to: base.Add(3 * time.Hour), expected: 3},
+		{from: base.Add(3 * time.Hour), to: time.Time{}, expected: 2},
+		{from: time.Time{}, to: base, expected: 1},
+	} {
+		subs, count, err := FetchSubmissionList(db, "aplusb", "", "", "", false, []SubmissionOrder{ID_DESC}, 0, 10, WithSubmissionTimeRange(tc.from, tc.to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.expected || int64(len(subs)) != tc.expected {
+			t.Fatal("invalid count", tc.from, tc.to, count, len(subs), "!=", tc.expected)
+		}
+	}
+}
+
+func TestSubmissionListTimeMemoryRange(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, sub := range []Submission{
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 50, MaxMemory: 1 << 20},
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 150, MaxMemory: 1 << 10},
+		{ProblemName: "aplusb", Status: "WA", MaxTime: 80, MaxMemory: 1 << 30},
+		{ProblemName: "aplusb", Status: "WJ", MaxTime: -1, MaxMemory: -1},
+	} {
+		if _, err := SaveSubmission(db, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		status   string
+		options  []SubmissionListOption
+		expected int64
+	}{
+		{options: []SubmissionListOption{WithMaxTimeRange(ptr[int32](0), ptr[int32](100))}, expected: 2},
+		{status: "AC", options: []SubmissionListOption{WithMaxTimeRange(ptr[int32](0), ptr[int32](100))}, expected: 1},
+		{options: []SubmissionListOption{WithMaxMemoryRange(ptr[int64](0), ptr[int64](1<<20))}, expected: 2},
+		{options: []SubmissionListOption{WithMaxTimeRange(ptr[int32](0), ptr[int32](100)), WithMaxMemoryRange(ptr[int64](0), ptr[int64](1<<20))}, expected: 1},
+		// open-ended ranges
+		{options: []SubmissionListOption{WithMaxTimeRange(nil, nil)}, expected: 4},
+		{options: []SubmissionListOption{WithMaxTimeRange(ptr[int32](80), nil)}, expected: 2},
+		{options: []SubmissionListOption{WithMaxTimeRange(nil, ptr[int32](80))}, expected: 3},
+		{options: []SubmissionListOption{WithMaxMemoryRange(ptr[int64](1<<20), nil)}, expected: 2},
+		{options: []SubmissionListOption{WithMaxMemoryRange(nil, ptr[int64](1<<10))}, expected: 2},
+	} {
+		subs, count, err := FetchSubmissionList(db, "aplusb", tc.status, "", "", false, []SubmissionOrder{ID_DESC}, 0, 10, tc.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.expected || int64(len(subs)) != tc.expected {
+			t.Fatal("invalid count", count, len(subs), "!=", tc.expected)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestSubmissionListStatuses(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, status := range []string{"AC", "WA", "TLE", "RE", "WJ", "AC"} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			Status:      status,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		statuses []string
+		expected int64
+	}{
+		{statuses: nil, expected: 6},
+		{statuses: []string{"AC"}, expected: 2},
+		{statuses: []string{"WA", "TLE", "RE"}, expected: 3},
+	} {
+		subs, count, err := FetchSubmissionList(db, "", "", "", "", false, []SubmissionOrder{ID_DESC}, 0, 2, WithStatuses(tc.statuses...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.expected {
+			t.Fatal("invalid count", tc.statuses, count, "!=", tc.expected)
+		}
+		for _, sub := range subs {
+			if len(tc.statuses) != 0 && !slices.Contains(tc.statuses, sub.Status) {
+				t.Fatal("invalid status", tc.statuses, sub)
+			}
+		}
+	}
+}
+
+func TestSubmissionListHacked(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, hacked := range []bool{true, false, false} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			Hacked:      hacked,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		options  []SubmissionListOption
+		expected int64
+	}{
+		{options: nil, expected: 3},
+		{options: []SubmissionListOption{WithHacked(true)}, expected: 1},
+		{options: []SubmissionListOption{WithHacked(false)}, expected: 2},
+	} {
+		subs, count, err := FetchSubmissionList(db, "", "", "", "", false, []SubmissionOrder{ID_DESC}, 0, 10, tc.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.expected || int64(len(subs)) != tc.expected {
+			t.Fatal("invalid count", count, len(subs), "!=", tc.expected)
+		}
+	}
+}
+
+func TestSubmissionListJudgedBy(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, judgedBy := range []string{"judge1", "judge2", "judge1", ""} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			JudgedBy:    judgedBy,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, count, err := FetchSubmissionList(db, "", "", "", "", false, []SubmissionOrder{ID_DESC}, 0, 10, WithJudgedBy("judge1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(subs) != 2 || subs[0].ID != 3 || subs[1].ID != 1 {
+		t.Fatal("invalid submissions", count, subs)
+	}
+}
+
+func TestSubmissionThroughput(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+	if err := SaveProblem(db, Problem{Name: "many_aplusb", Title: "Many A + B"}); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, sub := range []struct {
+		problem string
+		minutes int
+	}{
+		{"aplusb", -10},
+		{"aplusb", 0},
+		{"aplusb", 59},
+		{"many_aplusb", 30},
+		{"aplusb", 150},
+		{"aplusb", 180},
+	} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName:    sub.problem,
+			SubmissionTime: base.Add(time.Duration(sub.minutes) * time.Minute),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		problem  string
+		expected []int64
+	}{
+		{"", []int64{3, 0, 1}},
+		{"aplusb", []int64{2, 0, 1}},
+	} {
+		buckets, err := SubmissionThroughput(db, test.problem, "", base, base.Add(3*time.Hour), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := []int64{}
+		for i, bucket := range buckets {
+			if !bucket.Start.Equal(base.Add(time.Duration(i) * time.Hour)) {
+				t.Fatal("invalid bucket start", bucket)
+			}
+			counts = append(counts, bucket.Count)
+		}
+		if !reflect.DeepEqual(counts, test.expected) {
+			t.Fatal("invalid throughput", test.problem, counts)
+		}
+	}
+
+	if _, err := SubmissionThroughput(db, "", "", base, base.Add(time.Hour), time.Millisecond); err == nil {
+		t.Fatal("too small bucket is accepted")
+	}
+	if _, err := SubmissionThroughput(db, "", "", base, base, time.Hour); err == nil {
+		t.Fatal("empty range is accepted")
+	}
+}
+
+func TestContestSubmissions(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, sub := range []struct {
+		contestID sql.NullInt32
+		minutes   int
+	}{
+		{sql.NullInt32{Int32: 1, Valid: true}, 10},
+		{sql.NullInt32{}, 20},
+		{sql.NullInt32{Int32: 1, Valid: true}, 200},
+		{sql.NullInt32{Int32: 2, Valid: true}, 30},
+		{sql.NullInt32{Int32: 1, Valid: true}, 5},
+	} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName:    "aplusb",
+			SubmissionTime: base.Add(time.Duration(sub.minutes) * time.Minute),
+			ContestID:      sub.contestID,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, count, err := FetchSubmissionList(db, "aplusb", "", "", "", false, []SubmissionOrder{ID_DESC}, 0, 10, WithContest(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(subs) != 3 || subs[0].ID != 5 || !subs[0].ContestID.Valid || subs[0].ContestID.Int32 != 1 {
+		t.Fatal("invalid submissions", count, subs)
+	}
+
+	subs, err = FetchContestSubmissions(db, 1, base, base.Add(120*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int32{}
+	for _, sub := range subs {
+		ids = append(ids, sub.ID)
+	}
+	if !reflect.DeepEqual(ids, []int32{5, 1}) {
+		t.Fatal("invalid contest submissions", ids)
+	}
+
+	subs, err = FetchContestSubmissions(db, 3, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 0 {
+		t.Fatal("submissions of unknown contest are found", subs)
+	}
+}
+
+func TestFetchSubmissionsWithStaleTestcases(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, version := range []string{"old", "new", "old", "older", "new"} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName:      "aplusb",
+			TestCasesVersion: version,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		offset, limit int
+		expected      []int32
+	}{
+		{0, 10, []int32{1, 3, 4}},
+		{0, 2, []int32{1, 3}},
+		{2, 2, []int32{4}},
+	} {
+		subs, err := FetchSubmissionsWithStaleTestcases(db, "aplusb", "new", test.offset, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int32{}
+		for _, sub := range subs {
+			ids = append(ids, sub.ID)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Fatal("invalid stale submissions", test, ids)
+		}
+	}
+
+	if _, err := FetchSubmissionsWithStaleTestcases(db, "", "new", 0, 10); err == nil {
+		t.Fatal("empty problem name is accepted")
+	}
+}
+
+func TestFetchIdenticalSubmissions(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+	if err := SaveProblem(db, Problem{
+		Name:      "aplusb2",
+		Title:     "A + B 2",
+		Timelimit: 2000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user1", "user2"} {
+		if err := RegisterUser(db, name, "id-"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := mapSourceStore{}
+	ids := []int32{}
+	for _, sub := range []struct {
+		problem string
+		user    string
+		source  string
+		store   SourceStore
+	}{
+		{"aplusb", "user1", "copied", nil},
+		{"aplusb", "user1", "copied", nil},
+		{"aplusb", "user2", "copied", store},
+		{"aplusb", "", "copied", nil},
+		{"aplusb", "user2", "original", nil},
+		{"aplusb2", "user2", "copied", nil},
+	} {
+		id, err := SaveSubmissionWithStore(db, sub.store, Submission{
+			ProblemName: sub.problem,
+			UserName:    sql.NullString{Valid: sub.user != "", String: sub.user},
+			Source:      sub.source,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, test := range []struct {
+		otherUsersOnly bool
+		expected       []int32
+	}{
+		{false, []int32{ids[1], ids[2], ids[3]}},
+		{true, []int32{ids[2], ids[3]}},
+	} {
+		subs, err := FetchIdenticalSubmissions(db, ids[0], test.otherUsersOnly, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := []int32{}
+		for _, s := range subs {
+			actual = append(actual, s.ID)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatal("Error FetchIdenticalSubmissions", test.otherUsersOnly, actual)
+		}
+	}
+
+	if subs, err := FetchIdenticalSubmissions(db, ids[4], false, 10); err != nil || len(subs) != 0 {
+		t.Fatal("unique source has identical submissions", subs, err)
+	}
+	if _, err := FetchIdenticalSubmissions(db, ids[5]+1, false, 10); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchUsedLanguages(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+	if err := RegisterUser(db, "user1", "id1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []struct {
+		lang string
+		user string
+	}{
+		{"python3", "user1"},
+		{"cpp", "user1"},
+		{"cpp", "user1"},
+		{"rust", ""},
+	} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			Lang:        sub.lang,
+			UserName:    sql.NullString{Valid: sub.user != "", String: sub.user},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		problem  string
+		user     string
+		expected []string
+	}{
+		{"aplusb", "", []string{"cpp", "python3", "rust"}},
+		{"aplusb", "user1", []string{"cpp", "python3"}},
+		{"aplusb", "user2", []string{}},
+	} {
+		langs, err := FetchUsedLanguages(db, test.problem, test.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(langs, test.expected) {
+			t.Fatal("Error FetchUsedLanguages", test.user, langs)
+		}
+	}
+
+	if _, err := FetchUsedLanguages(db, "", ""); err == nil {
+		t.Fatal("empty problem name is accepted")
+	}
+}
+
+func TestFetchBestSubmissionsForUser(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+	if err := SaveProblem(db, Problem{Name: "many_aplusb", Title: "Many A + B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveProblem(db, Problem{Name: "unique", Title: "Unique"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterUser(db, "user1", "id1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterUser(db, "user2", "id2"); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, sub := range []struct {
+		problem string
+		user    string
+		status  string
+		maxTime int32
+		minutes int
+	}{
+		{"aplusb", "user1", "WA", 10, 0},
+		{"aplusb", "user1", "AC", 100, 1},
+		{"aplusb", "user1", "AC", 50, 2}, // 3: fastest AC
+		{"aplusb", "user1", "AC", 50, 3},
+		{"aplusb", "user1", "TLE", 2000, 4},
+		{"many_aplusb", "user1", "WA", 10, 5},
+		{"many_aplusb", "user1", "RE", 20, 6}, // 7: latest
+		{"many_aplusb", "user2", "AC", 10, 7},
+		{"unique", "user1", "AC", 10, 8},
+	} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName:    sub.problem,
+			UserName:       sql.NullString{String: sub.user, Valid: true},
+			Status:         sub.status,
+			MaxTime:        sub.maxTime,
+			SubmissionTime: base.Add(time.Duration(sub.minutes) * time.Minute),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, err := FetchBestSubmissionsForUser(db, "user1", []string{"aplusb", "many_aplusb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int32{}
+	for _, sub := range subs {
+		ids = append(ids, sub.ID)
+	}
+	if !reflect.DeepEqual(ids, []int32{3, 7}) {
+		t.Fatal("invalid best submissions", ids)
+	}
+
+	subs, err = FetchBestSubmissionsForUser(db, "user1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 || subs[2].ProblemName != "unique" {
+		t.Fatal("invalid best submissions of all problems", subs)
+	}
+
+	if _, err := FetchBestSubmissionsForUser(db, "", nil); err == nil {
+		t.Fatal("empty user name is accepted")
+	}
+}
+
+func TestSaveTestcaseResults(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveTestcaseResult(db, SubmissionTestcaseResult{
+		Submission: id,
+		Testcase:   "case1.in",
+		Status:     "WA",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results := []SubmissionTestcaseResult{
+		{Submission: id, Testcase: "case1.in", Status: "AC", Time: 12, Memory: 34, Stderr: []byte{}, CheckerOut: []byte{}},
+		{Submission: id, Testcase: "case2.in", Status: "TLE", Time: 56, Memory: 78, Stderr: []byte{}, CheckerOut: []byte{}},
+	}
+	if err := SaveTestcaseResults(db, results); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := FetchTestcaseResults(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, results) {
+		t.Fatal(actual, "!=", results)
+	}
+
+	if err := SaveTestcaseResults(db, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmissionResultNaturalOrder(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"random_10", "example_01", "random_2", "random_100", "example_00", "handmade", "random_09"} {
+		if err := SaveTestcaseResult(db, SubmissionTestcaseResult{
+			Submission: id,
+			Testcase:   name,
+			Status:     "AC",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := FetchTestcaseResults(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Testcase)
+	}
+	expected := []string{"example_00", "example_01", "handmade", "random_2", "random_09", "random_10", "random_100"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatal(names, "!=", expected)
+	}
+}
+
+func TestSubmissionResultForeignKey(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveTestcaseResult(db, SubmissionTestcaseResult{
+		Submission: id + 1,
+		Testcase:   "case1.in",
+		Status:     "AC",
+	}); err == nil {
+		t.Fatal("result of non-existing submission is saved")
+	}
+
+	for _, name := range []string{"case1.in", "case2.in"} {
+		if err := SaveTestcaseResult(db, SubmissionTestcaseResult{
+			Submission: id,
+			Testcase:   name,
+			Status:     "AC",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Unscoped().Delete(&Submission{ID: id}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	count := int64(0)
+	if err := db.Model(&SubmissionTestcaseResult{}).Where("submission = ?", id).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("results are not deleted: ", count)
+	}
+}
+
+func TestCountSubmissionsByStatus(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	if err := RegisterUser(db, "user1", "id1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []Submission{
+		{ProblemName: "aplusb", Status: "AC", UserName: sql.NullString{Valid: true, String: "user1"}},
+		{ProblemName: "aplusb", Status: "AC"},
+		{ProblemName: "aplusb", Status: "WA", UserName: sql.NullString{Valid: true, String: "user1"}},
+		{ProblemName: "aplusb", Status: "TLE"},
+	} {
+		if _, err := SaveSubmission(db, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		counts, err := CountSubmissionsByStatus(db, "aplusb", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]int64{"AC": 2, "WA": 1, "TLE": 1}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Fatal(counts, "!=", expected)
+		}
+	}
+	{
+		counts, err := CountSubmissionsByStatus(db, "aplusb", "user1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]int64{"AC": 1, "WA": 1}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Fatal(counts, "!=", expected)
+		}
+	}
+}
+
+func TestFetchFastestAcceptedSubmissions(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, user := range []string{"user1", "user2"} {
+		if err := RegisterUser(db, user, "id-"+user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user1 := sql.NullString{Valid: true, String: "user1"}
+	user2 := sql.NullString{Valid: true, String: "user2"}
+
+	now := time.Now()
+	for _, sub := range []Submission{
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 200, UserName: user1, SubmissionTime: now},                 // 1
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 100, UserName: user1, SubmissionTime: now},                 // 2
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 100, UserName: user1, SubmissionTime: now.Add(-time.Hour)}, // 3
+		{ProblemName: "aplusb", Status: "WA", MaxTime: 10, UserName: user1, SubmissionTime: now},                  // 4
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 150, UserName: user2, SubmissionTime: now},                 // 5
+		{ProblemName: "aplusb", Status: "AC", MaxTime: 50, SubmissionTime: now},                                   // 6
+	} {
+		if _, err := SaveSubmission(db, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs, err := FetchFastestAcceptedSubmissions(db, "aplusb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int32{}
+	for _, sub := range subs {
+		ids = append(ids, sub.ID)
+	}
+	if !reflect.DeepEqual(ids, []int32{3, 5}) {
+		t.Fatal("invalid submissions: ", ids)
+	}
+}
+
+func TestAcceptedProblems(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+	if err := SaveProblem(db, Problem{
+		Name:  "unionfind",
+		Title: "Union Find",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveProblem(db, Problem{
+		Name:  "lca",
+		Title: "LCA",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterUser(db, "user1", "id1"); err != nil {
+		t.Fatal(err)
+	}
+	user1 := sql.NullString{Valid: true, String: "user1"}
+
+	for _, sub := range []Submission{
+		{ProblemName: "aplusb", Status: "AC", UserName: user1},
+		{ProblemName: "aplusb", Status: "AC", UserName: user1},
+		{ProblemName: "unionfind", Status: "AC", UserName: user1},
+		{ProblemName: "lca", Status: "WA", UserName: user1},
+		{ProblemName: "lca", Status: "AC"},
+	} {
+		if _, err := SaveSubmission(db, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := CountAcceptedProblems(db, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("count is not 2: ", count)
+	}
+
+	problems, err := FetchAcceptedProblems(db, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(problems, []string{"aplusb", "unionfind"}) {
+		t.Fatal("invalid problems: ", problems)
+	}
+}
+
+func TestSearchSubmissionsBySource(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for _, source := range []string{
+		"int main() { return 0; }",
+		"#include <bits/stdc++.h>\nINT MAIN() {}",
+		"fn main() {}",
+		"printf(\"100%_done\")",
+	} {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			Source:      source,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	developer := User{Name: "dev", IsDeveloper: true}
+
+	if _, err := SearchSubmissionsBySource(db, User{Name: "user"}, "main", 0, 10); err != ErrPermissionDenied {
+		t.Fatal("non developer can search: ", err)
+	}
+
+	for _, tc := range []struct {
+		substr   string
+		expected []int32
+	}{
+		{substr: "int main", expected: []int32{2, 1}},
+		{substr: "main()", expected: []int32{3, 2, 1}},
+		{substr: "0%_", expected: []int32{4}},
+		{substr: "%", expected: []int32{4}},
+	} {
+		subs, err := SearchSubmissionsBySource(db, developer, tc.substr, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int32{}
+		for _, sub := range subs {
+			ids = append(ids, sub.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Fatal("invalid result", tc.substr, ids, "!=", tc.expected)
+		}
+	}
+}
+
+func TestSoftDeleteSubmission(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	for i := 0; i < 3; i++ {
+		if _, err := SaveSubmission(db, Submission{
+			ProblemName: "aplusb",
+			Status:      "AC",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveTestcaseResult(db, SubmissionTestcaseResult{
+		Submission: 2,
+		Testcase:   "case1.in",
+		Status:     "AC",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	developer := User{Name: "dev", IsDeveloper: true}
+
+	if err := DeleteSubmission(db, User{Name: "user"}, 2); err != ErrPermissionDenied {
+		t.Fatal("non developer can delete: ", err)
+	}
+	if err := DeleteSubmission(db, developer, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FetchSubmission(db, 2); err != ErrNotExist {
+		t.Fatal("deleted submission is fetched: ", err)
+	}
+	if results, err := FetchTestcaseResults(db, 2); err != nil || len(results) != 0 {
+		t.Fatal("results of deleted submission are fetched: ", results, err)
+	}
+	if _, count, err := FetchSubmissionList(db, "", "", "", "", false, []SubmissionOrder{ID_DESC}, 0, 10); err != nil || count != 2 {
+		t.Fatal("deleted submission is counted: ", count, err)
+	}
+	if counts, err := CountSubmissionsByStatus(db, "aplusb", ""); err != nil || counts["AC"] != 2 {
+		t.Fatal("deleted submission is counted: ", counts, err)
+	}
+
+	deleted, err := FetchDeletedSubmissions(db, developer, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != 2 {
+		t.Fatal("invalid deleted submissions: ", deleted)
+	}
+
+	if err := RestoreSubmission(db, developer, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreSubmission(db, developer, 2); err != ErrNotExist {
+		t.Fatal("restore not deleted submission: ", err)
+	}
+	if _, err := FetchSubmission(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := FetchTestcaseResults(db, 2); err != nil || len(results) != 1 {
+		t.Fatal("results of restored submission are not fetched: ", results, err)
+	}
+}
+
+type mapSourceStore map[string]string
+
+func (s mapSourceStore) Save(id int32, source string) (string, error) {
+	key := fmt.Sprintf("source-%d", id)
+	s[key] = source
+	return key, nil
+}
+
+func (s mapSourceStore) Load(key string) (string, error) {
+	source, ok := s[key]
+	if !ok {
+		return "", ErrNotExist
+	}
+	return source, nil
+}
+
+func (s mapSourceStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestSubmissionSource(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	store := mapSourceStore{}
+	inlineID, err := SaveSubmissionWithStore(db, nil, Submission{ProblemName: "aplusb", Source: "inline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalID, err := SaveSubmissionWithStore(db, store, Submission{ProblemName: "aplusb", Source: "external"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		id       int32
+		expected string
+	}{
+		{inlineID, "inline"},
+		{externalID, "external"},
+	} {
+		sub, err := FetchSubmission(db, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub.Source != "" {
+			t.Fatal("source is loaded by FetchSubmission", sub.Source)
+		}
+		// UpdateSubmission keeps the source
+		if err := UpdateSubmission(db, sub); err != nil {
+			t.Fatal(err)
+		}
+		source, err := FetchSubmissionSource(db, store, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source != test.expected {
+			t.Fatal("invalid source", test.id, source)
+		}
+	}
+
+	if _, err := FetchSubmissionSource(db, nil, externalID); err == nil {
+		t.Fatal("external source is loaded without store")
+	}
+	if _, err := FetchSubmissionSource(db, store, externalID+1); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateSubmissionConflict(t *testing.T) {
+	db := CreateTestDB(t)
+
+	createDummyProblem(t, db)
+
+	id, err := SaveSubmission(db, Submission{
+		ProblemName: "aplusb",
+		Source:      "source",
+		Status:      "-",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	judge1, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	judge2, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	judge1.Status = "Compiling"
+	if err := UpdateSubmission(db, judge1); err != nil {
+		t.Fatal(err)
+	}
+	judge2.Status = "WA"
+	if err := UpdateSubmission(db, judge2); err != ErrSubmissionConflict {
+		t.Fatal("stale update is not rejected: ", err)
+	}
+
+	// partial updates also invalidate stale submissions
+	judge1.Version++
+	if err := UpdateSubmissionStatus(db, id, judge1.Version, "1/10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSubmissionStatus(db, id, judge1.Version, "2/10"); err != ErrSubmissionConflict {
+		t.Fatal("stale update is not rejected: ", err)
+	}
+	if err := UpdateSubmission(db, judge1); err != ErrSubmissionConflict {
+		t.Fatal("stale update is not rejected: ", err)
+	}
+
+	sub, err := FetchSubmission(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := FetchSubmissionSource(db, nil, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != "1/10" || source != "source" || sub.Version != 2 {
+		t.Fatal("invalid data", sub, source)
+	}
+	sub.Status = "AC"
+	if err := UpdateSubmission(db, sub); err != nil {
+		t.Fatal(err)
+	}
+}
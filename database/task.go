@@ -12,6 +12,8 @@ import (
 
 const TASK_RETRY_PERIOD = time.Minute
 
+var ErrTaskNotHeld = errors.New("task is not held")
+
 type TaskType = int
 
 const (
@@ -32,6 +34,7 @@ type Task struct {
 	Priority  int32
 	Available time.Time
 	Enqueue   time.Time
+	Holder    string
 	TaskData  []byte
 }
 
@@ -78,11 +81,13 @@ func PushTask(db *gorm.DB, taskData TaskData, priority int32) error {
 	return nil
 }
 
-func PopTask(db *gorm.DB) (int32, TaskData, error) {
+// PopTask takes the task with the highest priority and holds it for TASK_RETRY_PERIOD.
+// The task being taken by another judge is skipped, so judges never take the same task nor wait for each other.
+func PopTask(db *gorm.DB, holder string) (int32, TaskData, error) {
 	task := Task{}
 	found := false
 	if err := db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("available <= ?", time.Now()).Order("priority desc, id asc").Clauses(clause.Locking{Strength: "UPDATE"}).Take(&task).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := tx.Where("available <= ?", time.Now()).Order("priority desc, id asc").Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Take(&task).Error; errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil
 		} else if err != nil {
 			return err
@@ -91,6 +96,7 @@ func PopTask(db *gorm.DB) (int32, TaskData, error) {
 		found = true
 
 		task.Available = time.Now().Add(TASK_RETRY_PERIOD)
+		task.Holder = holder
 		if err := tx.Save(&task).Error; err != nil {
 			return err
 		}
@@ -134,6 +140,50 @@ func TouchTask(db *gorm.DB, id int32) error {
 	return nil
 }
 
+// RenewTask extends the period of the task only if holder still holds it.
+// It returns ErrTaskNotHeld if the task is expired or taken by another holder.
+func RenewTask(db *gorm.DB, id int32, holder string) error {
+	now := time.Now()
+	result := db.Model(&Task{}).
+		Where("id = ? AND holder = ? AND available > ?", id, holder, now).
+		Update("available", now.Add(TASK_RETRY_PERIOD))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTaskNotHeld
+	}
+	return nil
+}
+
+// ReleaseTask makes the task held by holder available again immediately, e.g. to retry it on another judge.
+// It returns ErrTaskNotHeld if the task is expired or taken by another holder.
+func ReleaseTask(db *gorm.DB, id int32, holder string) error {
+	now := time.Now()
+	result := db.Model(&Task{}).
+		Where("id = ? AND holder = ? AND available > ?", id, holder, now).
+		Updates(map[string]interface{}{"available": now, "holder": ""})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTaskNotHeld
+	}
+	return nil
+}
+
+// CleanStaleTaskHolders clears the holder of expired tasks and returns the number of them.
+// Expired tasks are not deleted because they will be popped again.
+func CleanStaleTaskHolders(db *gorm.DB) (int64, error) {
+	result := db.Model(&Task{}).
+		Where("holder <> '' AND available <= ?", time.Now()).
+		Update("holder", "")
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
 func FinishTask(db *gorm.DB, taskId int32) error {
 	if err := db.Delete(&Task{
 		ID: taskId,
@@ -2,6 +2,7 @@ package database
 
 import (
 	"testing"
+	"time"
 )
 
 func TestTask(t *testing.T) {
@@ -14,17 +15,17 @@ func TestTask(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	id1, data1, err := PopTask(db)
+	id1, data1, err := PopTask(db, "judge")
 	if id1 == -1 || data1.Hack != 456 || err != nil {
 		t.Fatal(id1, data1, err)
 	}
 
-	id2, data2, err := PopTask(db)
+	id2, data2, err := PopTask(db, "judge")
 	if id2 == -1 || data2.Submission != 123 || err != nil {
 		t.Fatal(id2, data2, err)
 	}
 
-	id3, data3, err := PopTask(db)
+	id3, data3, err := PopTask(db, "judge")
 	if id3 != -1 || err != nil {
 		t.Fatal(id3, data3, err)
 	}
@@ -59,22 +60,168 @@ func TestTaskSamePriority(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	id1, data1, err := PopTask(db)
+	id1, data1, err := PopTask(db, "judge")
 	if id1 == -1 || data1.Submission != 123 || err != nil {
 		t.Fatal(id1, data1, err)
 	}
 
-	id2, data2, err := PopTask(db)
+	id2, data2, err := PopTask(db, "judge")
 	if id2 == -1 || data2.Submission != 124 || err != nil {
 		t.Fatal(id2, data2, err)
 	}
 
-	id3, data3, err := PopTask(db)
+	id3, data3, err := PopTask(db, "judge")
 	if id3 == -1 || data3.Submission != 125 || err != nil {
 		t.Fatal(data3, err)
 	}
 }
 
+func TestRenewTask(t *testing.T) {
+	db := CreateTestDB(t)
+
+	if err := PushSubmissionTask(db, 123, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := PopTask(db, "judge1")
+	if id == -1 || err != nil {
+		t.Fatal(id, err)
+	}
+
+	if err := RenewTask(db, id, "judge1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenewTask(db, id, "judge2"); err != ErrTaskNotHeld {
+		t.Fatal("renewed by other holder: ", err)
+	}
+
+	// expire the task and let judge2 take it
+	if err := db.Model(&Task{ID: id}).Update("available", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := RenewTask(db, id, "judge1"); err != ErrTaskNotHeld {
+		t.Fatal("expired task is renewed: ", err)
+	}
+	id2, _, err := PopTask(db, "judge2")
+	if id2 != id || err != nil {
+		t.Fatal(id2, err)
+	}
+	if err := RenewTask(db, id, "judge1"); err != ErrTaskNotHeld {
+		t.Fatal("renewed by old holder: ", err)
+	}
+	if err := RenewTask(db, id, "judge2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseTask(t *testing.T) {
+	db := CreateTestDB(t)
+
+	if err := PushSubmissionTask(db, 123, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := PopTask(db, "judge1")
+	if id == -1 || err != nil {
+		t.Fatal(id, err)
+	}
+	if err := ReleaseTask(db, id, "judge2"); err != ErrTaskNotHeld {
+		t.Fatal("released by other holder: ", err)
+	}
+	if err := ReleaseTask(db, id, "judge1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenewTask(db, id, "judge1"); err != ErrTaskNotHeld {
+		t.Fatal("released task is renewed: ", err)
+	}
+
+	// released task is available immediately
+	id2, data, err := PopTask(db, "judge2")
+	if id2 != id || err != nil || data.Submission != 123 {
+		t.Fatal(id2, data, err)
+	}
+}
+
+func TestRenewTaskAdvancesAvailable(t *testing.T) {
+	db := CreateTestDB(t)
+
+	if err := PushSubmissionTask(db, 123, 1); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := PopTask(db, "judge")
+	if id == -1 || err != nil {
+		t.Fatal(id, err)
+	}
+
+	before := Task{ID: id}
+	if err := db.Take(&before).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := RenewTask(db, id, "judge"); err != nil {
+		t.Fatal(err)
+	}
+
+	after := Task{ID: id}
+	if err := db.Take(&after).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !before.Available.Before(after.Available) {
+		t.Fatal("available is not advanced", before.Available, after.Available)
+	}
+}
+
+func TestCleanStaleTaskHolders(t *testing.T) {
+	db := CreateTestDB(t)
+
+	for i := int32(0); i < 3; i++ {
+		if err := PushSubmissionTask(db, i, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expiredID, _, err := PopTask(db, "judge1")
+	if expiredID == -1 || err != nil {
+		t.Fatal(expiredID, err)
+	}
+	freshID, _, err := PopTask(db, "judge2")
+	if freshID == -1 || err != nil {
+		t.Fatal(freshID, err)
+	}
+	if err := db.Model(&Task{ID: expiredID}).Update("available", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := CleanStaleTaskHolders(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatal("count is not 1: ", count)
+	}
+
+	var tasks []Task
+	if err := db.Order("id").Find(&tasks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 3 {
+		t.Fatal("tasks are deleted: ", tasks)
+	}
+	for _, task := range tasks {
+		if task.ID == freshID && task.Holder != "judge2" {
+			t.Fatal("fresh task is cleaned: ", task)
+		}
+		if task.ID != freshID && task.Holder != "" {
+			t.Fatal("stale task is not cleaned: ", task)
+		}
+	}
+
+	if err := RenewTask(db, freshID, "judge2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestTaskDataSerialize(t *testing.T) {
 	task := TaskData{
 		TaskType:   JUDGE_SUBMISSION,
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yosupo06/library-checker-judge/storage"
+)
+
+const (
+	// BUILTIN_DIFF accepts the output same as the expected one, except for trailing whitespaces of lines and trailing empty lines
+	BUILTIN_DIFF = "diff"
+	// BUILTIN_WCMP compares tokens separated by whitespaces
+	BUILTIN_WCMP = "wcmp"
+	// BUILTIN_FCMP compares tokens like BUILTIN_WCMP, but numbers are compared with AbsEps / RelEps
+	BUILTIN_FCMP = "fcmp"
+
+	// MAX_BUILTIN_TOKEN_LENGTH is the max length of tokens and lines, longer ones of the output are WA
+	MAX_BUILTIN_TOKEN_LENGTH = 16 << 20
+)
+
+// BuiltinChecker compares outputs in the judge without compiling checker.cpp, for problems with simple outputs.
+// Its results follow the exit codes of testlib, so they are mapped to the same verdicts.
+type BuiltinChecker struct {
+	Mode string
+	// numbers of BUILTIN_FCMP are accepted if the absolute or relative error is at most these values
+	AbsEps float64
+	RelEps float64
+}
+
+func (c BuiltinChecker) validate() error {
+	if c.Mode != BUILTIN_DIFF && c.Mode != BUILTIN_WCMP && c.Mode != BUILTIN_FCMP {
+		return fmt.Errorf("unknown builtin checker: %q", c.Mode)
+	}
+	if c.AbsEps < 0 || c.RelEps < 0 || math.IsNaN(c.AbsEps) || math.IsNaN(c.RelEps) {
+		return fmt.Errorf("invalid eps: %v %v", c.AbsEps, c.RelEps)
+	}
+	return nil
+}
+
+// builtinCheckerOf returns the builtin checker of the problem, or nil if the problem uses checker.cpp
+func builtinCheckerOf(info storage.Info) *BuiltinChecker {
+	if info.BuiltinChecker == "" {
+		return nil
+	}
+	return &BuiltinChecker{
+		Mode:   info.BuiltinChecker,
+		AbsEps: info.CheckerAbsEps,
+		RelEps: info.CheckerRelEps,
+	}
+}
+
+func (c BuiltinChecker) check(inFilePath, expectFilePath, actualFilePath string, sourceResult TaskResult) (TaskResult, error) {
+	start := time.Now()
+
+	expectFile, err := os.Open(expectFilePath)
+	if err != nil {
+		return TaskResult{}, ioError(err)
+	}
+	defer expectFile.Close()
+	actualFile, err := os.Open(actualFilePath)
+	if err != nil {
+		return TaskResult{}, ioError(err)
+	}
+	defer actualFile.Close()
+
+	var exitCode int
+	var message string
+	if c.Mode == BUILTIN_DIFF {
+		exitCode, message, err = compareLines(expectFile, actualFile)
+	} else {
+		exitCode, message, err = c.compareTokens(expectFile, actualFile)
+	}
+	if err != nil {
+		return TaskResult{}, ioError(err)
+	}
+	return TaskResult{ExitCode: exitCode, Time: time.Since(start), Stderr: []byte(message)}, nil
+}
+
+// compareLines compares the outputs line by line without reading them into memory
+func compareLines(expect, actual io.Reader) (int, string, error) {
+	expectScanner := newBuiltinScanner(expect, bufio.ScanLines)
+	actualScanner := newBuiltinScanner(actual, bufio.ScanLines)
+	for idx := 1; ; idx++ {
+		eok := expectScanner.Scan()
+		aok := actualScanner.Scan()
+		if err := expectScanner.Err(); err != nil {
+			return 0, "", err
+		}
+		if err := actualScanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+			return 1, fmt.Sprintf("wrong answer: line %d is too long", idx), nil
+		} else if err != nil {
+			return 0, "", err
+		}
+		if !eok && !aok {
+			return 0, fmt.Sprintf("ok %d lines", idx-1), nil
+		}
+		// the rest of the other output must be empty lines
+		if !eok || !aok {
+			rest, reason := actualScanner, "extra line"
+			if !aok {
+				rest, reason = expectScanner, "line is missing"
+			}
+			line, err := firstNonEmptyLine(rest, idx)
+			if errors.Is(err, bufio.ErrTooLong) && rest == actualScanner {
+				return 1, fmt.Sprintf("wrong answer: line %d is too long", line), nil
+			} else if err != nil {
+				return 0, "", err
+			}
+			if line != 0 {
+				return 1, fmt.Sprintf("wrong answer: %s %d", reason, line), nil
+			}
+			return 0, fmt.Sprintf("ok %d lines", idx-1), nil
+		}
+		if trimLine(expectScanner.Text()) != trimLine(actualScanner.Text()) {
+			return 1, fmt.Sprintf("wrong answer: line %d differs", idx), nil
+		}
+	}
+}
+
+// firstNonEmptyLine returns the number of the first non empty line of scanner from the current line idx, or 0 if there is no such line
+func firstNonEmptyLine(scanner *bufio.Scanner, idx int) (int, error) {
+	for {
+		if trimLine(scanner.Text()) != "" {
+			return idx, nil
+		}
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return idx + 1, err
+			}
+			return 0, nil
+		}
+		idx++
+	}
+}
+
+func trimLine(line string) string {
+	return strings.TrimRight(line, " \t\r")
+}
+
+func (c BuiltinChecker) compareTokens(expect, actual io.Reader) (int, string, error) {
+	expectScanner := newBuiltinScanner(expect, bufio.ScanWords)
+	actualScanner := newBuiltinScanner(actual, bufio.ScanWords)
+	for idx := 1; ; idx++ {
+		eok := expectScanner.Scan()
+		aok := actualScanner.Scan()
+		if err := expectScanner.Err(); err != nil {
+			return 0, "", err
+		}
+		if err := actualScanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+			return 1, fmt.Sprintf("wrong answer: token %d is too long", idx), nil
+		} else if err != nil {
+			return 0, "", err
+		}
+		if !eok && !aok {
+			return 0, fmt.Sprintf("ok %d tokens", idx-1), nil
+		}
+		if !eok {
+			return 1, fmt.Sprintf("wrong answer: extra token %d", idx), nil
+		}
+		if !aok {
+			return 1, fmt.Sprintf("wrong answer: token %d is missing", idx), nil
+		}
+		e, a := expectScanner.Text(), actualScanner.Text()
+		if e == a {
+			continue
+		}
+		if c.Mode == BUILTIN_FCMP {
+			if ev, err := strconv.ParseFloat(e, 64); err == nil {
+				av, err := strconv.ParseFloat(a, 64)
+				if err != nil {
+					return 2, fmt.Sprintf("wrong output format: token %d is not a number", idx), nil
+				}
+				if c.closeEnough(ev, av) {
+					continue
+				}
+			}
+		}
+		return 1, fmt.Sprintf("wrong answer: token %d differs", idx), nil
+	}
+}
+
+func (c BuiltinChecker) closeEnough(expect, actual float64) bool {
+	if math.IsNaN(expect) || math.IsNaN(actual) {
+		return false
+	}
+	diff := math.Abs(expect - actual)
+	return diff <= c.AbsEps || diff <= c.RelEps*math.Abs(expect)
+}
+
+func newBuiltinScanner(r io.Reader, split bufio.SplitFunc) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_BUILTIN_TOKEN_LENGTH)
+	scanner.Split(split)
+	return scanner
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinChecker(t *testing.T) {
+	for _, test := range []struct {
+		checker  BuiltinChecker
+		expect   string
+		actual   string
+		exitCode int
+	}{
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1 2\n3\n", "1 2\n3\n", 0},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1 2\n3\n", "1 2  \r\n3", 0},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1 2\n3\n", "1 2\n3\n\n\n", 0},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1 2\n3\n", "1  2\n3\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1 2\n3\n", "1 2 3\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1\n", "", 1},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1\n\n", "1\n", 0},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1\n", "1\n\n2\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1\n\n2\n", "1\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_DIFF}, "1\n", "1\n" + strings.Repeat("a", MAX_BUILTIN_TOKEN_LENGTH+1), 1},
+		{BuiltinChecker{Mode: BUILTIN_WCMP}, "1\n", strings.Repeat("1", MAX_BUILTIN_TOKEN_LENGTH+1), 1},
+		{BuiltinChecker{Mode: BUILTIN_WCMP}, "1 2\n3\n", "1\n2 3", 0},
+		{BuiltinChecker{Mode: BUILTIN_WCMP}, "", "", 0},
+		{BuiltinChecker{Mode: BUILTIN_WCMP}, "1 2\n3\n", "1 2\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_WCMP}, "1 2\n", "1 2 3\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_WCMP}, "1.0\n", "1\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, AbsEps: 1e-6}, "Yes 1.0\n", "Yes 1.0000001\n", 0},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, AbsEps: 1e-6}, "Yes 1.0\n", "Yes 1.001\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, AbsEps: 1e-6}, "Yes 1.0\n", "No 1.0\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, RelEps: 1e-6}, "1000000\n", "1000000.5\n", 0},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, RelEps: 1e-6}, "1\n", "1.5\n", 1},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, AbsEps: 1e-6}, "1.0\n", "one\n", 2},
+		{BuiltinChecker{Mode: BUILTIN_FCMP, AbsEps: 1e-6}, "1.0\n", "nan\n", 1},
+	} {
+		expectFile := toRealFile(bytes.NewBufferString(test.expect), "expect.out", t)
+		actualFile := toRealFile(bytes.NewBufferString(test.actual), "actual.out", t)
+		result, err := test.checker.check("", expectFile, actualFile, TaskResult{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitCode != test.exitCode || len(result.Stderr) == 0 {
+			t.Fatalf("Error BuiltinChecker %v %q %q: %v", test.checker, test.expect, test.actual, result)
+		}
+	}
+}
+
+func TestBuiltinCheckerValidate(t *testing.T) {
+	for _, c := range []BuiltinChecker{
+		{Mode: BUILTIN_DIFF},
+		{Mode: BUILTIN_WCMP},
+		{Mode: BUILTIN_FCMP, AbsEps: 1e-9, RelEps: 1e-9},
+	} {
+		if err := c.validate(); err != nil {
+			t.Fatal(c, err)
+		}
+	}
+	for _, c := range []BuiltinChecker{
+		{},
+		{Mode: "rcmp"},
+		{Mode: BUILTIN_FCMP, AbsEps: -1},
+	} {
+		if err := c.validate(); err == nil {
+			t.Fatal("invalid builtin checker is accepted", c)
+		}
+	}
+}
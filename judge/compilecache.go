@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yosupo06/library-checker-judge/langs"
+	"github.com/yosupo06/library-checker-judge/storage"
+)
+
+// CompileCache keeps compiled sources in a host directory to skip compiling the same source again, e.g. on rejudges.
+// The entries are keyed by the source, the files copied with it, the compile command and the id of the compiler image,
+// so an update of the compiler or the problem invalidates them.
+// Judge processes on the same host can share the directory, entries are written to a temp dir and renamed.
+type CompileCache struct {
+	dir        string
+	maxEntries int
+}
+
+const (
+	DEFAULT_COMPILE_CACHE_ENTRIES = 1000
+	STALE_COMPILE_CACHE_TEMP_DIR  = time.Hour
+)
+
+func NewCompileCache(dir string, maxEntries int) (*CompileCache, error) {
+	if maxEntries <= 0 {
+		maxEntries = DEFAULT_COMPILE_CACHE_ENTRIES
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &CompileCache{dir: dir, maxEntries: maxEntries}, nil
+}
+
+// key returns the cache key of compiling srcPath with files and l
+func (c *CompileCache) key(srcPath string, files []string, l langs.Lang) (string, error) {
+	id, err := imageID(l.ImageName)
+	if err != nil {
+		return "", err
+	}
+	return compileCacheKey(id, srcPath, files, l)
+}
+
+func compileCacheKey(imageID string, srcPath string, files []string, l langs.Lang) (string, error) {
+	h := sha256.New()
+	header, err := json.Marshal(struct {
+		ImageID string
+		Lang    string
+		Source  string
+		Compile []string
+	}{imageID, l.ID, l.Source, l.Compile})
+	if err != nil {
+		return "", err
+	}
+	h.Write(header)
+
+	for _, p := range append([]string{srcPath}, files...) {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return "", err
+		}
+		// name and size separate the contents of files
+		name, err := json.Marshal(struct {
+			Name string
+			Size int64
+		}{path.Base(p), info.Size()})
+		if err != nil {
+			f.Close()
+			return "", err
+		}
+		h.Write(name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// load copies the entry of key into a new volume, ok is false if it is not found
+func (c *CompileCache) load(key string) (v Volume, ok bool, err error) {
+	entryDir := path.Join(c.dir, key)
+	if _, err := os.Stat(entryDir); errors.Is(err, os.ErrNotExist) {
+		return Volume{}, false, nil
+	} else if err != nil {
+		return Volume{}, false, err
+	}
+	// the entry is used recently
+	now := time.Now()
+	if err := os.Chtimes(entryDir, now, now); err != nil {
+		return Volume{}, false, err
+	}
+
+	v, err = CreateVolume()
+	if err != nil {
+		return Volume{}, false, err
+	}
+	if err := v.CopyFile(entryDir+"/.", "."); err != nil {
+		if err := v.Remove(); err != nil {
+			logger().Error("Volume remove failed", "err", err)
+		}
+		return Volume{}, false, err
+	}
+	return v, true, nil
+}
+
+// save copies v as the entry of key, and evicts the least recently used entries
+func (c *CompileCache) save(key string, v Volume) error {
+	tempDir, err := os.MkdirTemp(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if err := v.CopyTo(tempDir); err != nil {
+		os.RemoveAll(tempDir)
+		return err
+	}
+	if err := os.Rename(tempDir, path.Join(c.dir, key)); err != nil {
+		// the same entry is saved by another judge
+		os.RemoveAll(tempDir)
+		if _, statErr := os.Stat(path.Join(c.dir, key)); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return c.evict()
+}
+
+func (c *CompileCache) evict() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		name    string
+		modTime time.Time
+	}
+	cached := []entry{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			// removed by another judge
+			continue
+		}
+		if !e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), "tmp-") {
+			// left by a crashed judge
+			if time.Since(info.ModTime()) > STALE_COMPILE_CACHE_TEMP_DIR {
+				os.RemoveAll(path.Join(c.dir, e.Name()))
+			}
+			continue
+		}
+		cached = append(cached, entry{e.Name(), info.ModTime()})
+	}
+	if len(cached) <= c.maxEntries {
+		return nil
+	}
+	slices.SortFunc(cached, func(a, b entry) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, e := range cached[:len(cached)-c.maxEntries] {
+		logger().Debug("Evict compile cache", "key", e.name)
+		if err := os.RemoveAll(path.Join(c.dir, e.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileWithCache compiles like compile, but the volume is loaded from cache if the same source is compiled before.
+// Only successful compiles are cached, and the TaskResult of a cached compile is zero except for ExitCode.
+// Failures of cache don't fail the compile.
+func compileWithCache(cache *CompileCache, dir storage.ProblemFiles, srcPath string, l langs.Lang) (Volume, TaskResult, error) {
+	if cache == nil {
+		return compile(dir, srcPath, l)
+	}
+	files, err := compileFilePaths(dir, l)
+	if err != nil {
+		return Volume{}, TaskResult{}, err
+	}
+	key, err := cache.key(srcPath, files, l)
+	if err != nil {
+		logger().Error("Failed to get the key of compile cache", "err", err)
+		return compile(dir, srcPath, l)
+	}
+	if v, ok, err := cache.load(key); err != nil {
+		logger().Error("Failed to load compile cache", "err", err)
+	} else if ok {
+		logger().Info("Use compile cache", "lang", l.ID, "key", key)
+		return v, TaskResult{ExitCode: 0}, nil
+	}
+
+	v, t, err := compile(dir, srcPath, l)
+	if err != nil {
+		return Volume{}, TaskResult{}, err
+	}
+	if t.ExitCode == 0 {
+		if err := cache.save(key, v); err != nil {
+			logger().Error("Failed to save compile cache", "err", err)
+		}
+	}
+	return v, t, nil
+}
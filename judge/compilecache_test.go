@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/yosupo06/library-checker-judge/langs"
+)
+
+func TestCompileCacheKey(t *testing.T) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := path.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	src := write("main.cpp", "int main() {}")
+	params := write("params.h", "#define N 10")
+	otherSrc := write("other.cpp", "int main() { return 0; }")
+	otherParams := write("other.h", "#define N 10")
+
+	base, err := compileCacheKey("image1", src, []string{params}, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := compileCacheKey("image1", src, []string{params}, lang); err != nil || again != base {
+		t.Fatal("key is not stable", base, again, err)
+	}
+
+	flagged := lang
+	flagged.Compile = append(append([]string{}, lang.Compile...), "-DLOCAL")
+	for _, test := range []struct {
+		name    string
+		imageID string
+		src     string
+		files   []string
+		lang    langs.Lang
+	}{
+		{"image", "image2", src, []string{params}, lang},
+		{"source", "image1", otherSrc, []string{params}, lang},
+		{"file name", "image1", src, []string{otherParams}, lang},
+		{"no file", "image1", src, []string{}, lang},
+		{"compile command", "image1", src, []string{params}, flagged},
+	} {
+		key, err := compileCacheKey(test.imageID, test.src, test.files, test.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == base {
+			t.Fatal("key doesn't depend on", test.name)
+		}
+	}
+}
+
+func TestCompileCacheEvict(t *testing.T) {
+	c, err := NewCompileCache(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, name := range []string{"old", "middle", "new", "tmp-stale"} {
+		p := path.Join(c.dir, name)
+		if err := os.Mkdir(p, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-3) * time.Minute)
+		if name == "tmp-stale" {
+			modTime = now.Add(-2 * STALE_COMPILE_CACHE_TEMP_DIR)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.evict(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"old": false, "middle": true, "new": true, "tmp-stale": false} {
+		_, err := os.Stat(path.Join(c.dir, name))
+		if exists := err == nil; exists != expected {
+			t.Fatal("Error evict", name, exists)
+		}
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +21,49 @@ const (
 	MAX_STDERR_LENGTH = 1 << 10
 )
 
+// dockerPath is the name or the path of the docker command
+var dockerPath = "docker"
+
+// bindMountable reports whether files of the host can be bind mounted, i.e. the docker daemon runs on this host.
+// It is false if DOCKER_HOST is not a unix socket, and DOCKER_BIND_MOUNT overrides it, e.g. for a remote daemon behind DOCKER_PATH.
+var bindMountable = true
+
+func init() {
+	if p := os.Getenv("DOCKER_PATH"); p != "" {
+		dockerPath = p
+	}
+	if h := os.Getenv("DOCKER_HOST"); h != "" && !strings.HasPrefix(h, "unix://") {
+		bindMountable = false
+	}
+	if b := os.Getenv("DOCKER_BIND_MOUNT"); b != "" {
+		v, err := strconv.ParseBool(b)
+		if err != nil {
+			logger().Warn("Invalid DOCKER_BIND_MOUNT, ignored", "value", b)
+		} else {
+			bindMountable = v
+		}
+	}
+}
+
+// checkDocker returns an error if the docker command is not found
+func checkDocker() error {
+	if _, err := exec.LookPath(dockerPath); err != nil {
+		return fmt.Errorf("docker command %q is not found: %w", dockerPath, err)
+	}
+	return nil
+}
+
+// imageID returns the id of the docker image, it changes when the image (e.g. the compiler) is updated
+func imageID(image string) (string, error) {
+	cmd := exec.Command(dockerPath, "image", "inspect", "--format", "{{.Id}}", image)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 type Volume struct {
 	Name string
 }
@@ -31,14 +74,14 @@ func CreateVolume() (Volume, error) {
 	args := []string{"volume", "create"}
 	args = append(args, "--name", volumeName)
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
 
 	cmd.Stderr = os.Stderr
 
 	err := cmd.Run()
 
 	if err != nil {
-		log.Println("volume create failed:", err.Error())
+		logger().Error("volume create failed", "err", err)
 		return Volume{}, err
 	}
 
@@ -48,7 +91,7 @@ func CreateVolume() (Volume, error) {
 }
 
 func (v *Volume) CopyFile(srcPath string, dstPath string) error {
-	log.Printf("Copy file to %v:%v", v.Name, dstPath)
+	logger().Debug("Copy file", "volume", v.Name, "dst", dstPath)
 
 	task := TaskInfo{
 		VolumeMountInfo: []VolumeMountInfo{
@@ -68,10 +111,32 @@ func (v *Volume) CopyFile(srcPath string, dstPath string) error {
 	return ci.CopyFile(srcPath, path.Join("/workdir", dstPath))
 }
 
+// CopyTo copies all files in the volume into the host directory dstDir
+func (v *Volume) CopyTo(dstDir string) error {
+	logger().Debug("Copy volume", "volume", v.Name, "dst", dstDir)
+
+	task := TaskInfo{
+		VolumeMountInfo: []VolumeMountInfo{
+			{
+				Path:   "/workdir",
+				Volume: v,
+			},
+		},
+		Name: "ubuntu",
+	}
+	ci, err := task.create()
+	if err != nil {
+		return err
+	}
+	defer ci.Remove()
+
+	return ci.CopyFileFrom("/workdir/.", dstDir)
+}
+
 func (v *Volume) Remove() error {
 	args := []string{"volume", "rm", v.Name}
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
@@ -82,8 +147,14 @@ func (v *Volume) Remove() error {
 }
 
 type VolumeMountInfo struct {
-	Path   string
-	Volume *Volume
+	Path     string
+	Volume   *Volume
+	ReadOnly bool
+}
+
+type BindMountInfo struct {
+	HostPath string
+	Path     string
 }
 
 type ContainerMonitorBuilder func(c *containerInfo) (containerMonitor, error)
@@ -92,10 +163,10 @@ var DEFAULT_MONITOR_BUILDER ContainerMonitorBuilder
 
 func init() {
 	if _, ok := os.LookupEnv("LIBRARY_CHECKER_JUDGE"); ok {
-		log.Println("Started in judge server, use HighPrecisionContainerMonitor")
+		logger().Info("Started in judge server, use HighPrecisionContainerMonitor")
 		DEFAULT_MONITOR_BUILDER = NewHighPrecisionContainerMonitor
 	} else {
-		log.Println("Started in local, use LowPrecisionContainerMonitor")
+		logger().Info("Started in local, use LowPrecisionContainerMonitor")
 		DEFAULT_MONITOR_BUILDER = NewLowPrecisionContainerMonitor
 	}
 }
@@ -113,6 +184,8 @@ type TaskInfo struct {
 	WorkDir             string
 	cgroupParent        string
 	VolumeMountInfo     []VolumeMountInfo
+	BindMountInfo       []BindMountInfo
+	Env                 []string // KEY=VALUE
 	monitorBuilder      ContainerMonitorBuilder
 
 	Stdin  io.Reader
@@ -194,6 +267,40 @@ func WithVolume(volume *Volume, containerPath string) TaskInfoOption {
 	}
 }
 
+// WithReadOnlyVolume mounts the volume to containerPath as read only, e.g. to share compiled binaries between runs
+func WithReadOnlyVolume(volume *Volume, containerPath string) TaskInfoOption {
+	return func(ti *TaskInfo) error {
+		ti.VolumeMountInfo = append(ti.VolumeMountInfo, VolumeMountInfo{
+			Path:     containerPath,
+			Volume:   volume,
+			ReadOnly: true,
+		})
+		return nil
+	}
+}
+
+// WithReadOnlyBind mounts the host file (or directory) to containerPath as read only
+func WithReadOnlyBind(hostPath string, containerPath string) TaskInfoOption {
+	return func(ti *TaskInfo) error {
+		absPath, err := filepath.Abs(hostPath)
+		if err != nil {
+			return err
+		}
+		ti.BindMountInfo = append(ti.BindMountInfo, BindMountInfo{
+			HostPath: absPath,
+			Path:     containerPath,
+		})
+		return nil
+	}
+}
+
+func WithEnv(key, value string) TaskInfoOption {
+	return func(ti *TaskInfo) error {
+		ti.Env = append(ti.Env, key+"="+value)
+		return nil
+	}
+}
+
 func WithMonitorBuilder(builder ContainerMonitorBuilder) TaskInfoOption {
 	return func(ti *TaskInfo) error {
 		ti.monitorBuilder = builder
@@ -219,11 +326,49 @@ func NewTaskInfo(name string, ops ...TaskInfoOption) (*TaskInfo, error) {
 }
 
 type TaskResult struct {
-	ExitCode int
-	Time     time.Duration
-	Memory   int64
-	TLE      bool
-	Stderr   []byte
+	ExitCode  int
+	Time      time.Duration
+	Memory    int64
+	TLE       bool
+	TLEReason TLEReason // empty unless TLE
+	OOMKilled bool      // killed by the memory cgroup
+	Stderr    []byte
+	Stats     ResourceStats
+}
+
+// TLEReason tells why a TLE task used the time. TLE is only decided by the wall time.
+type TLEReason string
+
+const (
+	// the task was waiting, e.g. sleeping or blocked on I/O
+	TLE_WALL_TIME TLEReason = "wall"
+	// the task was busy on CPU until the timeout
+	TLE_CPU_TIME TLEReason = "cpu"
+)
+
+// CPU_BOUND_RATIO is the ratio of the CPU time to the timeout of TLE tasks regarded as TLE_CPU_TIME
+const CPU_BOUND_RATIO = 0.9
+
+// tleReason returns the reason of TLE, or empty if the task is in time, i.e. not killed and the wall time is in the timeout.
+// The CPU time is only used to tell the reason, it doesn't make an in-time task TLE.
+func tleReason(killed bool, timeout, usedTime time.Duration, stats ResourceStats) TLEReason {
+	if !killed && (timeout == 0 || usedTime <= timeout) {
+		return ""
+	}
+	// the CPU time is the sum of all threads
+	if float64(stats.UserTime+stats.SystemTime) >= CPU_BOUND_RATIO*float64(timeout) {
+		return TLE_CPU_TIME
+	}
+	return TLE_WALL_TIME
+}
+
+// ResourceStats is the additional resource usage read from the cgroup of the container.
+// The fields are zero if the cgroup doesn't provide them.
+type ResourceStats struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+	ReadBytes  int64
+	WriteBytes int64
 }
 
 func (t *TaskInfo) Run() (result TaskResult, err error) {
@@ -299,7 +444,23 @@ func (t *TaskInfo) create() (containerInfo, error) {
 	// mount volume
 	for _, volumeMount := range t.VolumeMountInfo {
 		args = append(args, "-v")
-		args = append(args, fmt.Sprintf("%s:%s", volumeMount.Volume.Name, volumeMount.Path))
+		if volumeMount.ReadOnly {
+			args = append(args, fmt.Sprintf("%s:%s:ro", volumeMount.Volume.Name, volumeMount.Path))
+		} else {
+			args = append(args, fmt.Sprintf("%s:%s", volumeMount.Volume.Name, volumeMount.Path))
+		}
+	}
+
+	// bind mount
+	for _, bindMount := range t.BindMountInfo {
+		args = append(args, "--mount")
+		args = append(args, fmt.Sprintf("type=bind,src=%s,dst=%s,readonly", bindMount.HostPath, bindMount.Path))
+	}
+
+	// environment variables
+	for _, env := range t.Env {
+		args = append(args, "-e")
+		args = append(args, env)
 	}
 
 	// cgroup parent
@@ -313,14 +474,14 @@ func (t *TaskInfo) create() (containerInfo, error) {
 	// extra arguments
 	args = append(args, t.Argments...)
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
 
 	cmd.Stderr = os.Stderr
 
 	output, err := cmd.Output()
 
 	if err != nil {
-		log.Println("create failed:", err.Error())
+		logger().Error("create failed", "err", err)
 		return containerInfo{}, err
 	}
 
@@ -347,12 +508,14 @@ func (t *TaskInfo) start(c containerInfo) (TaskResult, error) {
 
 	args = append(args, c.containerID)
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := exec.CommandContext(ctx, dockerPath, args...)
 
 	cmd.Stdin = t.Stdin
 	cmd.Stdout = t.Stdout
 	stderr := NewLimitedWriter(MAX_STDERR_LENGTH)
 	cmd.Stderr = stderr
+	// don't wait for the pipes forever after the process is killed by ctx
+	cmd.WaitDelay = time.Second
 
 	monitorBuilder := t.monitorBuilder
 	if monitorBuilder == nil {
@@ -361,15 +524,15 @@ func (t *TaskInfo) start(c containerInfo) (TaskResult, error) {
 	cm, err := monitorBuilder(&c)
 	if err != nil {
 		if _, ok := err.(*exec.ExitError); !ok {
-			log.Println("create monitor failed:", err.Error())
+			logger().Error("create monitor failed", "err", err)
 			return TaskResult{}, err
 		}
 	}
 	cm.start()
 	err = cmd.Run()
 	if err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			log.Println("execute failed:", err.Error())
+		if _, ok := err.(*exec.ExitError); !ok && !errors.Is(err, exec.ErrWaitDelay) {
+			logger().Error("execute failed", "err", err)
 			return TaskResult{}, err
 		}
 	}
@@ -377,41 +540,48 @@ func (t *TaskInfo) start(c containerInfo) (TaskResult, error) {
 
 	if ctx.Err() == context.DeadlineExceeded {
 		// stop docker
-		cmd := exec.Command("docker", "stop", c.containerID)
+		cmd := exec.Command(dockerPath, "stop", c.containerID)
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
-			log.Println("failed to stop docker:", err)
+			logger().Error("failed to stop docker", "err", err)
 			return TaskResult{}, err
 		}
 
+		// stderr written until the kill is kept
+		stats := cm.resourceStats()
 		return TaskResult{
-			Time:     t.Timeout,
-			Memory:   cm.maxUsedMemory(),
-			TLE:      true,
-			ExitCode: 124,
+			Time:      t.Timeout,
+			Memory:    cm.maxUsedMemory(),
+			TLE:       true,
+			TLEReason: tleReason(true, t.Timeout, t.Timeout, stats),
+			ExitCode:  124,
+			Stderr:    stderr.Bytes(),
+			Stats:     stats,
 		}, nil
 	}
 
 	usedTime := cm.usedTime()
-	tle := false
-
-	if t.Timeout != 0 && t.Timeout < usedTime {
+	stats := cm.resourceStats()
+	reason := tleReason(false, t.Timeout, usedTime, stats)
+	if reason != "" {
 		usedTime = t.Timeout
-		tle = true
 	}
 
-	exitCode, err := inspectExitCode(c.containerID)
+	exitCode, oomKilled, err := inspectState(c.containerID)
 	if err != nil {
-		log.Println("failed to load exit code: ", err)
+		logger().Error("failed to load exit code", "err", err)
 		return TaskResult{}, err
 	}
 
 	return TaskResult{
-		Time:     usedTime,
-		Memory:   cm.maxUsedMemory(),
-		TLE:      tle,
-		ExitCode: exitCode,
-		Stderr:   stderr.Bytes(),
+		Time:      usedTime,
+		Memory:    cm.maxUsedMemory(),
+		TLE:       reason != "",
+		TLEReason: reason,
+		ExitCode:  exitCode,
+		OOMKilled: oomKilled,
+		Stderr:    stderr.Bytes(),
+		Stats:     stats,
 	}, nil
 }
 
@@ -421,6 +591,7 @@ type containerMonitor interface {
 
 	usedTime() time.Duration
 	maxUsedMemory() int64
+	resourceStats() ResourceStats
 }
 
 // A highPrecisionContainerMonitor measures used time in high precision.
@@ -436,9 +607,16 @@ type highPrecisionContainerMonitor struct {
 	startTime time.Time
 	endTime   time.Time
 
-	maxMemory int64
+	maxMemory     int64
+	stats         ResourceStats
+	statsReadAt   time.Time
+	statsFinished bool
 }
 
+// RESOURCE_STATS_INTERVAL is the interval to read cpu.stat and io.stat while the task is running.
+// They are read again once the task exits, so this only matters for the killed tasks.
+const RESOURCE_STATS_INTERVAL = 100 * time.Millisecond
+
 func NewHighPrecisionContainerMonitor(c *containerInfo) (containerMonitor, error) {
 	cm := highPrecisionContainerMonitor{
 		c:             c,
@@ -460,7 +638,8 @@ func (cm *highPrecisionContainerMonitor) start() {
 				return
 			case <-cm.ticker.C:
 				tasks, err := cm.c.readCGroupTasks()
-				if err == nil && len(tasks) >= 2 {
+				running := err == nil && len(tasks) >= 2
+				if running {
 					if !cm.isStarted {
 						cm.isStarted = true
 						cm.startTime = time.Now()
@@ -472,6 +651,16 @@ func (cm *highPrecisionContainerMonitor) start() {
 						cm.maxMemory = usedMemory
 					}
 				}
+				// the cgroup is removed with the container, so the stats are read while it exists:
+				// once when the task exits, and on a coarse interval until then in case it is killed
+				exited := cm.isStarted && !running
+				if !cm.statsFinished && (exited || time.Since(cm.statsReadAt) >= RESOURCE_STATS_INTERVAL) {
+					if stats, err := cm.c.readResourceStats(); err == nil {
+						cm.stats = stats
+					}
+					cm.statsReadAt = time.Now()
+					cm.statsFinished = exited
+				}
 			}
 		}
 	}()
@@ -491,6 +680,10 @@ func (cm *highPrecisionContainerMonitor) maxUsedMemory() int64 {
 	return cm.maxMemory
 }
 
+func (cm *highPrecisionContainerMonitor) resourceStats() ResourceStats {
+	return cm.stats
+}
+
 type lowPrecisionContainerMonitor struct {
 	c   *containerInfo
 	hcm containerMonitor
@@ -537,10 +730,14 @@ func (cm *lowPrecisionContainerMonitor) maxUsedMemory() int64 {
 	return cm.hcm.maxUsedMemory()
 }
 
+func (cm *lowPrecisionContainerMonitor) resourceStats() ResourceStats {
+	return cm.hcm.resourceStats()
+}
+
 func (cm *lowPrecisionContainerMonitor) parseDate(output []byte) (time.Time, error) {
 	date, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(output)))
 	if err != nil {
-		log.Println("failed to parse date: ", err.Error())
+		logger().Error("failed to parse date", "output", rawOutput(output), "err", err)
 		return time.Unix(0, 0), err
 	}
 	return date, nil
@@ -554,7 +751,7 @@ type containerInfo struct {
 func (c *containerInfo) Remove() error {
 	args := []string{"container", "rm", c.containerID}
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
@@ -576,7 +773,19 @@ func readCGroupTasksFromFile(filePath string) ([]string, error) {
 func (c *containerInfo) CopyFile(src string, dst string) error {
 	args := []string{"cp", src, c.containerID + ":" + dst}
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *containerInfo) CopyFileFrom(src string, dst string) error {
+	args := []string{"cp", c.containerID + ":" + src, dst}
+
+	cmd := exec.Command(dockerPath, args...)
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
@@ -617,7 +826,7 @@ func readUsedMemoryFromFile(filePath string) (int64, error) {
 
 	result, err := strconv.ParseInt(strings.TrimSpace(string(bytes)), 10, 64)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("failed to parse %v %s: %w", filePath, rawOutput(bytes), err)
 	}
 	return result, nil
 }
@@ -632,25 +841,134 @@ func (c *containerInfo) readUsedMemory() (int64, error) {
 	return 0, errors.New("failed to load memory usage")
 }
 
-func inspectExitCode(containerId string) (int, error) {
+func (c *containerInfo) readResourceStats() (ResourceStats, error) {
+	for _, dir := range c.cgroupDirs() {
+		cpuStat, err := ioutil.ReadFile(path.Join(dir, "cpu.stat"))
+		if err != nil {
+			continue
+		}
+		stats, err := parseCPUStat(cpuStat)
+		if err != nil {
+			return ResourceStats{}, err
+		}
+		// io.stat is missing if the io controller is not enabled
+		if ioStat, err := ioutil.ReadFile(path.Join(dir, "io.stat")); err == nil {
+			if stats.ReadBytes, stats.WriteBytes, err = parseIOStat(ioStat); err != nil {
+				return ResourceStats{}, err
+			}
+		}
+		return stats, nil
+	}
+
+	return ResourceStats{}, errors.New("failed to load resource stats")
+}
+
+// parseCPUStat parses cpu.stat of cgroup v2, e.g. "usage_usec 300\nuser_usec 200\nsystem_usec 100\n"
+func parseCPUStat(output []byte) (ResourceStats, error) {
+	stats := ResourceStats{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return ResourceStats{}, fmt.Errorf("failed to parse cpu.stat %s", rawOutput(output))
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return ResourceStats{}, fmt.Errorf("failed to parse cpu.stat %s: %w", rawOutput(output), err)
+		}
+		switch fields[0] {
+		case "user_usec":
+			stats.UserTime = time.Duration(value) * time.Microsecond
+		case "system_usec":
+			stats.SystemTime = time.Duration(value) * time.Microsecond
+		}
+	}
+	return stats, nil
+}
+
+// parseIOStat parses io.stat of cgroup v2 and returns the total bytes read and written on all devices,
+// e.g. "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n"
+func parseIOStat(output []byte) (int64, int64, error) {
+	readBytes, writeBytes := int64(0), int64(0)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// fields[0] is the device
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return 0, 0, fmt.Errorf("failed to parse io.stat %s", rawOutput(output))
+			}
+			if key != "rbytes" && key != "wbytes" {
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to parse io.stat %s: %w", rawOutput(output), err)
+			}
+			if key == "rbytes" {
+				readBytes += n
+			} else {
+				writeBytes += n
+			}
+		}
+	}
+	return readBytes, writeBytes, nil
+}
+
+// inspectState returns the exit code of the container and whether it was killed by OOM
+func inspectState(containerId string) (int, bool, error) {
 	args := []string{"inspect"}
 
 	args = append(args, containerId)
-	args = append(args, "--format={{.State.ExitCode}}")
+	args = append(args, "--format={{.State.ExitCode}} {{.State.OOMKilled}}")
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
 
 	output, err := cmd.Output()
 	if err != nil {
-		return 0, err
+		return 0, false, withCommandStderr(err)
 	}
 
-	code, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 32)
+	return parseState(output)
+}
+
+// parseState parses the output of inspectState, the raw output is included in the error
+func parseState(output []byte) (int, bool, error) {
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return 0, false, fmt.Errorf("unexpected inspect output: %s", rawOutput(output))
+	}
+	code, err := strconv.ParseInt(fields[0], 10, 32)
 	if err != nil {
-		return 0, err
+		return 0, false, fmt.Errorf("failed to parse inspect output %s: %w", rawOutput(output), err)
+	}
+	oomKilled, err := strconv.ParseBool(fields[1])
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to parse inspect output %s: %w", rawOutput(output), err)
+	}
+
+	return int(code), oomKilled, nil
+}
+
+const MAX_RAW_OUTPUT_LENGTH = 256
+
+// rawOutput returns the quoted prefix of output for error messages
+func rawOutput(output []byte) string {
+	if len(output) > MAX_RAW_OUTPUT_LENGTH {
+		return fmt.Sprintf("%q...", output[:MAX_RAW_OUTPUT_LENGTH])
 	}
+	return fmt.Sprintf("%q", output)
+}
 
-	return int(code), nil
+// withCommandStderr adds the stderr captured by exec.Cmd.Output to err
+func withCommandStderr(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) != 0 {
+		return fmt.Errorf("%w: %s", err, rawOutput(exitErr.Stderr))
+	}
+	return err
 }
 
 func readInspect(containerId string, args ...string) ([]byte, error) {
@@ -658,10 +976,11 @@ func readInspect(containerId string, args ...string) ([]byte, error) {
 		"inspect",
 		containerId,
 	}, args...)
-	cmd := exec.Command("docker", args...)
+	cmd := exec.Command(dockerPath, args...)
 	output, err := cmd.Output()
 	if err != nil {
-		log.Println("failed to read inspect:", err.Error())
+		err = withCommandStderr(err)
+		logger().Error("failed to read inspect", "err", err)
 	}
 	return output, err
 }
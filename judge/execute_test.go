@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"path"
@@ -10,7 +11,7 @@ import (
 	"time"
 )
 
-func toRealFile(src io.Reader, name string, t *testing.T) string {
+func toRealFile(src io.Reader, name string, t testing.TB) string {
 	tmpDir, err := os.MkdirTemp("", "")
 	if err != nil {
 		t.Fatal(err)
@@ -170,6 +171,27 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+func TestTimeoutStderr(t *testing.T) {
+	task, err := NewTaskInfo("ubuntu", WithArguments("sh", "-c", "echo dummy >&2; sleep 10"), WithTimeout(3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := task.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("task result: %v\n", result)
+
+	if !result.TLE {
+		t.Error("TLE is not detected")
+	}
+
+	if strings.TrimSpace(string(result.Stderr)) != "dummy" {
+		t.Errorf("Invalid Stderr: %s", result.Stderr)
+	}
+}
+
 func TestMemoryLimit(t *testing.T) {
 	// this command consumes 800M memory
 	task, err := NewTaskInfo("ubuntu", WithArguments("dd", "if=/dev/zero", "of=/dev/null", "bs=800M"), WithTimeout(3*time.Second), WithMemoryLimitMB(500))
@@ -227,6 +249,90 @@ func TestVolume(t *testing.T) {
 	}
 }
 
+func TestReadOnlyBind(t *testing.T) {
+	file := toRealFile(bytes.NewBufferString("dummy"), "dummy", t)
+	defer os.Remove(file)
+
+	output := new(bytes.Buffer)
+	task, err := NewTaskInfo("ubuntu", WithArguments("sh", "-c", "cat /casedir/test.txt && ! touch /casedir/test.txt"), WithReadOnlyBind(file, "/casedir/test.txt"), WithStdout(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := task.Run()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("task result: %v\n", result)
+
+	if result.ExitCode != 0 {
+		t.Errorf("Invalid exit code (not 0): %v", result.ExitCode)
+	}
+
+	if strings.TrimSpace(output.String()) != "dummy" {
+		t.Errorf("Invalid Stdout: %s", output.String())
+	}
+}
+
+func TestReadOnlyVolume(t *testing.T) {
+	volume, err := CreateVolume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer volume.Remove()
+
+	file := toRealFile(bytes.NewBufferString("dummy"), "dummy", t)
+	defer os.Remove(file)
+
+	if err := volume.CopyFile(file, "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	output := new(bytes.Buffer)
+
+	task, err := NewTaskInfo("ubuntu", WithArguments("sh", "-c", "cat /checker/test.txt && ! touch /checker/scratch.txt"), WithReadOnlyVolume(&volume, "/checker"), WithStdout(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := task.Run()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("task result: %v\n", result)
+
+	if result.ExitCode != 0 {
+		t.Errorf("Invalid exit code (not 0): %v", result.ExitCode)
+	}
+
+	if strings.TrimSpace(output.String()) != "dummy" {
+		t.Errorf("Invalid Stdout: %s", output.String())
+	}
+}
+
+func TestEnv(t *testing.T) {
+	output := new(bytes.Buffer)
+	task, err := NewTaskInfo("ubuntu", WithArguments("sh", "-c", "echo $SOLUTION_TIME_MS"), WithEnv("SOLUTION_TIME_MS", "123"), WithStdout(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := task.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitCode != 0 {
+		t.Errorf("Invalid exit code (not 0): %v", result.ExitCode)
+	}
+	if strings.TrimSpace(output.String()) != "123" {
+		t.Errorf("Invalid Stdout: %s", output.String())
+	}
+}
+
 func TestNetworkDisable(t *testing.T) {
 	task, err := NewTaskInfo("ibmcom/ping", WithArguments("ping", "-c", "5", "google.com"))
 	if err != nil {
@@ -334,3 +440,96 @@ func TestInvalidFileCopy(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestParseState(t *testing.T) {
+	code, oomKilled, err := parseState([]byte("137 true\n"))
+	if err != nil || code != 137 || !oomKilled {
+		t.Fatal("Error parseState", code, oomKilled, err)
+	}
+
+	for _, output := range []string{"", "137", "abc false", "0 maybe"} {
+		_, _, err := parseState([]byte(output))
+		if err == nil {
+			t.Fatal("invalid output is parsed", output)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("%q", output)) {
+			t.Fatal("raw output is not in the error", err)
+		}
+	}
+}
+
+func TestTLEReason(t *testing.T) {
+	for _, test := range []struct {
+		killed   bool
+		timeout  time.Duration
+		usedTime time.Duration
+		stats    ResourceStats
+		expected TLEReason
+	}{
+		{false, time.Second, 500 * time.Millisecond, ResourceStats{UserTime: 400 * time.Millisecond}, ""},
+		// the CPU time over the timeout doesn't make an in-time task TLE, e.g. multithreaded
+		{false, time.Second, 500 * time.Millisecond, ResourceStats{UserTime: 800 * time.Millisecond, SystemTime: 300 * time.Millisecond}, ""},
+		{false, time.Second, 1500 * time.Millisecond, ResourceStats{}, TLE_WALL_TIME},
+		{false, time.Second, 1500 * time.Millisecond, ResourceStats{UserTime: 3 * time.Second}, TLE_CPU_TIME},
+		{true, time.Second, time.Second, ResourceStats{UserTime: 950 * time.Millisecond}, TLE_CPU_TIME},
+		{true, time.Second, time.Second, ResourceStats{UserTime: 10 * time.Millisecond}, TLE_WALL_TIME},
+		{false, 0, time.Hour, ResourceStats{UserTime: time.Hour}, ""},
+	} {
+		if reason := tleReason(test.killed, test.timeout, test.usedTime, test.stats); reason != test.expected {
+			t.Fatal("Error tleReason", test, reason)
+		}
+	}
+}
+
+func TestParseCPUStat(t *testing.T) {
+	stats, err := parseCPUStat([]byte("usage_usec 3500\nuser_usec 3000\nsystem_usec 500\nnr_periods 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.UserTime != 3*time.Millisecond || stats.SystemTime != 500*time.Microsecond {
+		t.Fatal("Error parseCPUStat", stats)
+	}
+
+	for _, output := range []string{"user_usec", "user_usec abc"} {
+		if _, err := parseCPUStat([]byte(output)); err == nil {
+			t.Fatal("invalid output is parsed", output)
+		}
+	}
+}
+
+func TestParseIOStat(t *testing.T) {
+	readBytes, writeBytes, err := parseIOStat([]byte("8:0 rbytes=4096 wbytes=100 rios=1 wios=1 dbytes=0 dios=0\n8:16 rbytes=1 wbytes=2 rios=1 wios=1 dbytes=0 dios=0\n"))
+	if err != nil || readBytes != 4097 || writeBytes != 102 {
+		t.Fatal("Error parseIOStat", readBytes, writeBytes, err)
+	}
+
+	// empty if there is no io
+	readBytes, writeBytes, err = parseIOStat([]byte(""))
+	if err != nil || readBytes != 0 || writeBytes != 0 {
+		t.Fatal("Error parseIOStat", readBytes, writeBytes, err)
+	}
+
+	for _, output := range []string{"8:0 rbytes", "8:0 rbytes=abc"} {
+		if _, _, err := parseIOStat([]byte(output)); err == nil {
+			t.Fatal("invalid output is parsed", output)
+		}
+	}
+}
+
+func TestCheckDocker(t *testing.T) {
+	defaultDockerPath := dockerPath
+	t.Cleanup(func() { dockerPath = defaultDockerPath })
+
+	dockerPath = path.Join(t.TempDir(), "not-found-docker")
+	if err := checkDocker(); err == nil {
+		t.Fatal("missing docker is not detected")
+	}
+
+	dockerPath = path.Join(t.TempDir(), "fake-docker")
+	if err := os.WriteFile(dockerPath, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDocker(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log/slog"
 	"os"
 	"path"
 
@@ -14,8 +13,8 @@ import (
 	"gorm.io/gorm"
 )
 
-func execHackTask(db *gorm.DB, downloader storage.TestCaseDownloader, taskID int32, hackID int32) error {
-	slog.Info("Start hack judge", "hackID", hackID)
+func execHackTask(db *gorm.DB, downloader storage.TestCaseDownloader, sourceStore database.SourceStore, judgeName string, taskID int32, hackID int32) error {
+	logger().Info("Start hack judge", "hackID", hackID)
 
 	hack, err := database.FetchHack(db, hackID)
 	if err != nil {
@@ -25,9 +24,13 @@ func execHackTask(db *gorm.DB, downloader storage.TestCaseDownloader, taskID int
 	if err != nil {
 		return err
 	}
+	source, err := database.FetchSubmissionSource(db, sourceStore, hack.SubmissionID)
+	if err != nil {
+		return err
+	}
 	lang, ok := langs.GetLang(s.Lang)
 	if !ok {
-		return fmt.Errorf("unknown language: %v", lang)
+		return fmt.Errorf("unknown language %q", s.Lang)
 	}
 	p := storage.Problem{
 		Name:            s.Problem.Name,
@@ -45,17 +48,24 @@ func execHackTask(db *gorm.DB, downloader storage.TestCaseDownloader, taskID int
 	}
 
 	data := HackTaskData{
-		db:     db,
-		taskID: taskID,
-		files:  files,
-		info:   info,
-		h:      hack,
-		lang:   lang,
+		db:        db,
+		judgeName: judgeName,
+		taskID:    taskID,
+		files:     files,
+		info:      info,
+		h:         hack,
+		source:    source,
+		lang:      lang,
+		// the same language is used to compile and run even if langs are reloaded
+		modelSolution: langs.ModelSolution(),
 	}
 	if err := data.judge(); err != nil {
 		data.h.Status = "IE"
-		if err := data.updateHack(); err != nil {
-			slog.Error("Deep error", "err", err)
+		if IsRetryable(err) {
+			data.h.Status = "WJ"
+		}
+		if err2 := data.updateHack(); err2 != nil {
+			return errors.Join(err, fmt.Errorf("failed to update the status to %s: %w", data.h.Status, err2))
 		}
 		return err
 	}
@@ -64,12 +74,15 @@ func execHackTask(db *gorm.DB, downloader storage.TestCaseDownloader, taskID int
 }
 
 type HackTaskData struct {
-	db     *gorm.DB
-	taskID int32
-	files  storage.ProblemFiles
-	info   storage.Info
-	h      database.Hack
-	lang   langs.Lang
+	db            *gorm.DB
+	judgeName     string
+	taskID        int32
+	files         storage.ProblemFiles
+	info          storage.Info
+	h             database.Hack
+	source        string
+	lang          langs.Lang
+	modelSolution langs.Lang
 }
 
 func (data *HackTaskData) judge() error {
@@ -81,7 +94,7 @@ func (data *HackTaskData) judge() error {
 		return err
 	}
 	if inFilePath == "" {
-		slog.Info("Failed to generate test case")
+		logger().Info("Failed to generate test case")
 		return nil
 	}
 	defer os.Remove(inFilePath)
@@ -89,7 +102,7 @@ func (data *HackTaskData) judge() error {
 	if err := data.updateHackStatus("Compiling"); err != nil {
 		return err
 	}
-	slog.Info("Compile source")
+	logger().Info("Compile source")
 	sourceVolume, taskResult, err := data.compileSource()
 	if err != nil {
 		return err
@@ -98,29 +111,41 @@ func (data *HackTaskData) judge() error {
 	if taskResult.ExitCode != 0 {
 		return data.updateHackStatus("CE")
 	}
-	slog.Info("Compile checker")
-	checkerVolume, taskResult, err := compileChecker(data.files)
-	if err != nil {
-		return err
-	}
-	defer checkerVolume.Remove()
-	if taskResult.ExitCode != 0 {
-		return data.updateHackStatus("ICE")
+	var checker caseChecker
+	if c := builtinCheckerOf(data.info); c != nil {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		checker = *c
+	} else {
+		if err := validateCheckerArguments(data.info.CheckerArguments); err != nil {
+			return err
+		}
+		logger().Info("Compile checker")
+		checkerVolume, taskResult, err := compileChecker(data.files, data.info.CheckerCompileFlags...)
+		if err != nil {
+			return err
+		}
+		defer checkerVolume.Remove()
+		if taskResult.ExitCode != 0 {
+			return data.updateHackStatus("ICE")
+		}
+		checker = testlibChecker{volume: checkerVolume, args: data.info.CheckerArguments, passUsage: data.info.CheckerSolutionUsage}
 	}
-	slog.Info("Compile solution")
+	logger().Info("Compile solution")
 	solutionVolume, err := data.compileSolution()
 	if err != nil {
 		return err
 	}
 	defer solutionVolume.Remove()
-	slog.Info("Compile verifier")
+	logger().Info("Compile verifier")
 	verifierVolume, err := data.compileVerifier()
 	if err != nil {
 		return err
 	}
 	defer verifierVolume.Remove()
 
-	slog.Info("Verify input")
+	logger().Info("Verify input")
 	if err := data.updateHackStatus("Verifying"); err != nil {
 		return err
 	}
@@ -136,15 +161,24 @@ func (data *HackTaskData) judge() error {
 		return data.updateHackStatus("Invalid")
 	}
 
-	slog.Info("Generate model output")
+	logger().Info("Generate model output")
 	expectedFilePath, err := data.runModelSolution(solutionVolume, inFilePath)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(expectedFilePath)
 
-	slog.Info("Start executing")
-	result, err := runTestCase(sourceVolume, checkerVolume, data.lang, data.info.TimeLimit, inFilePath, expectedFilePath)
+	logger().Info("Start executing")
+	sourceOptions := []TaskInfoOption{}
+	memoryLimitBytes := int64(DEFAULT_MEMORY_LIMIT_MB) * 1024 * 1024
+	if data.info.MemoryLimitMB != 0 {
+		sourceOptions = append(sourceOptions, WithMemoryLimitMB(data.info.MemoryLimitMB))
+		memoryLimitBytes = int64(data.info.MemoryLimitMB) * 1024 * 1024
+	}
+	if data.info.StackLimitMB != 0 {
+		sourceOptions = append(sourceOptions, WithStackLimitKB(clampStackLimitKB(data.info.StackLimitMB*1024, memoryLimitBytes)))
+	}
+	result, err := runTestCase(sourceVolume, checker, data.lang, data.info.TimeLimit, timingPolicy(), inFilePath, expectedFilePath, "", sourceOptions...)
 	if err != nil {
 		return err
 	}
@@ -168,7 +202,7 @@ func (data *HackTaskData) compileSource() (Volume, TaskResult, error) {
 	if err != nil {
 		return Volume{}, TaskResult{}, err
 	}
-	if _, err := sourceFile.WriteString(data.h.Submission.Source); err != nil {
+	if _, err := sourceFile.WriteString(data.source); err != nil {
 		return Volume{}, TaskResult{}, err
 	}
 	sourceFile.Close()
@@ -177,8 +211,8 @@ func (data *HackTaskData) compileSource() (Volume, TaskResult, error) {
 }
 
 func (data *HackTaskData) compileSolution() (Volume, error) {
-	slog.Info("Compile solution")
-	v, r, err := compileModelSolution(data.files)
+	logger().Info("Compile solution")
+	v, r, err := compileModelSolution(data.files, data.modelSolution)
 	if err != nil {
 		return Volume{}, err
 	}
@@ -192,7 +226,7 @@ func (data *HackTaskData) compileSolution() (Volume, error) {
 }
 
 func (data *HackTaskData) compileVerifier() (Volume, error) {
-	slog.Info("Compile verifier")
+	logger().Info("Compile verifier")
 	v, r, err := compileVerifier(data.files)
 	if err != nil {
 		return Volume{}, err
@@ -207,47 +241,43 @@ func (data *HackTaskData) compileVerifier() (Volume, error) {
 }
 
 func (data *HackTaskData) generateTestCase() (string, error) {
-	slog.Info("Generate TestCase")
+	logger().Info("Generate TestCase")
 	if data.h.TestCaseCpp != nil {
 		tempFile, err := os.CreateTemp("", "")
 		if err != nil {
 			return "", err
 		}
+		defer os.Remove(tempFile.Name())
 		if _, err := tempFile.Write(data.h.TestCaseCpp); err != nil {
+			tempFile.Close()
 			return "", err
 		}
 		if err := tempFile.Close(); err != nil {
 			return "", err
 		}
-		defer os.Remove(tempFile.Name())
 
-		v, r, err := compile(data.files, tempFile.Name(), langs.LANG_GENERATOR)
+		result, err := generateInput(data.files, tempFile.Name())
 		if err != nil {
 			return "", err
 		}
-		if r.ExitCode != 0 {
-			data.h.JudgeOutput = r.Stderr
-			return "", data.updateHackStatus("GCE")
-		}
-		path, r, err := runGenerator(v)
-		if err != nil {
-			return "", err
-		}
-		if r.ExitCode != 0 {
-			data.h.JudgeOutput = r.Stderr
-			return "", data.updateHackStatus("GE")
+		if result.Status != "" {
+			data.h.JudgeOutput = result.TaskResult.Stderr
+			return "", data.updateHackStatus(result.Status)
 		}
 
-		return path, nil
+		return result.InFilePath, nil
 	} else if data.h.TestCaseTxt != nil {
-		tempFile, err := os.CreateTemp("", "")
+		tempFile, err := createMountableTemp()
 		if err != nil {
 			return "", err
 		}
 		if _, err := tempFile.Write(data.h.TestCaseTxt); err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
 			return "", err
 		}
 		if err := tempFile.Close(); err != nil {
+			os.Remove(tempFile.Name())
 			return "", err
 		}
 		inFilePath := tempFile.Name()
@@ -258,8 +288,8 @@ func (data *HackTaskData) generateTestCase() (string, error) {
 }
 
 func (data *HackTaskData) runModelSolution(v Volume, inFilePath string) (string, error) {
-	slog.Info("Generate model output")
-	path, r, err := runSource(v, langs.LANG_MODEL_SOLUTION, data.info.TimeLimit, inFilePath)
+	logger().Info("Generate model output")
+	path, r, err := runSource(v, data.modelSolution, data.info.TimeLimit, inFilePath)
 	if err != nil {
 		return "", err
 	}
@@ -274,7 +304,7 @@ func (data *HackTaskData) runModelSolution(v Volume, inFilePath string) (string,
 
 func (data *HackTaskData) updateHackStatus(status string) error {
 	data.h.Status = status
-	if err := database.TouchTask(data.db, data.taskID); err != nil {
+	if err := database.RenewTask(data.db, data.taskID, data.judgeName); err != nil {
 		return err
 	}
 	if err := database.UpdateHack(data.db, data.h); err != nil {
@@ -284,7 +314,7 @@ func (data *HackTaskData) updateHackStatus(status string) error {
 }
 
 func (data *HackTaskData) updateHack() error {
-	if err := database.TouchTask(data.db, data.taskID); err != nil {
+	if err := database.RenewTask(data.db, data.taskID, data.judgeName); err != nil {
 		return err
 	}
 	if err := database.UpdateHack(data.db, data.h); err != nil {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/yosupo06/library-checker-judge/langs"
+)
+
+// MIN_TEMP_FREE_BYTES is the free space of the temp dir required to judge, test cases and outputs are written there
+const MIN_TEMP_FREE_BYTES = 1 << 30
+
+type Health struct {
+	TempDir       string `json:"temp_dir"`
+	TempFreeBytes uint64 `json:"temp_free_bytes"`
+	Docker        bool   `json:"docker"`
+	Testlib       bool   `json:"testlib"`
+	Langs         int    `json:"langs"`
+	// Problems are the reasons why the judge is unhealthy, empty if it is healthy
+	Problems []string `json:"problems"`
+}
+
+func (s Health) OK() bool {
+	return len(s.Problems) == 0
+}
+
+// HealthStatus checks the free space of the temp dir, docker, testlib.h (TESTLIB_PATH) and the language config.
+// It doesn't run any container and is cheap enough to call every few seconds.
+func HealthStatus() Health {
+	s := Health{
+		TempDir:  os.TempDir(),
+		Problems: []string{},
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(s.TempDir, &stat); err != nil {
+		s.Problems = append(s.Problems, fmt.Sprintf("failed to stat temp dir %q: %v", s.TempDir, err))
+	} else {
+		s.TempFreeBytes = stat.Bavail * uint64(stat.Bsize)
+		if s.TempFreeBytes < MIN_TEMP_FREE_BYTES {
+			s.Problems = append(s.Problems, fmt.Sprintf("temp dir %q has only %d bytes free", s.TempDir, s.TempFreeBytes))
+		}
+	}
+
+	if err := checkDocker(); err != nil {
+		s.Problems = append(s.Problems, err.Error())
+	} else {
+		s.Docker = true
+	}
+
+	if testlibPath != "" {
+		if _, err := os.Stat(testlibPath); err != nil {
+			s.Problems = append(s.Problems, fmt.Sprintf("TESTLIB_PATH %q is not found: %v", testlibPath, err))
+		} else {
+			s.Testlib = true
+		}
+	} else {
+		// testlib.h of each problem is used
+		s.Testlib = true
+	}
+
+	s.Langs = len(langs.Langs())
+	if s.Langs == 0 {
+		s.Problems = append(s.Problems, "no language is loaded")
+	}
+	if langs.ModelSolution().ID == "" {
+		s.Problems = append(s.Problems, "the language of model solutions is not loaded")
+	}
+
+	return s
+}
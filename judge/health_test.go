@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestHealthStatusMissingTestlib(t *testing.T) {
+	oldPath := testlibPath
+	testlibPath = path.Join(t.TempDir(), "testlib.h")
+	t.Cleanup(func() { testlibPath = oldPath })
+
+	s := HealthStatus()
+	if s.OK() || s.Testlib {
+		t.Fatal("missing testlib.h is not reported", s)
+	}
+	found := false
+	for _, p := range s.Problems {
+		if strings.Contains(p, "TESTLIB_PATH") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("missing testlib.h is not in problems", s.Problems)
+	}
+	if s.Langs == 0 || s.TempFreeBytes == 0 {
+		t.Fatal("other checks are not done", s)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"compress/gzip"
 	"errors"
-	"log"
-	"log/slog"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/yosupo06/library-checker-judge/langs"
@@ -40,34 +45,690 @@ type CaseResult struct {
 	Time       time.Duration
 	Memory     int64
 	TLE        bool
+	TLEReason  TLEReason
 	Stderr     []byte
 	CheckerOut []byte
+	// Stats is the additional resource usage of the source
+	Stats ResourceStats
+	// FirstDiff is the first different token of WA, nil for other statuses
+	FirstDiff *TokenDiff
 }
 
-func compileChecker(dir storage.ProblemFiles) (Volume, TaskResult, error) {
-	return compile(dir, dir.CheckerPath(), langs.LANG_CHECKER)
+// Judge judges a source code with the checker of a problem
+type Judge struct {
+	files               storage.ProblemFiles
+	lang                langs.Lang
+	srcPath             string
+	timeLimit           float64
+	checkerCompileFlags []string
+	onCaseResult        func(idx int, result CaseResult) error
+	failurePolicy       FailurePolicy
+	timingPolicy        TimingPolicy
+	label               string
+	keepTempDir         bool
+	stackLimitKB        int
+	memoryLimitMB       int
+	passUsageToChecker  bool
+	compileCommand      []string
+	compileFlags        []string
+	builtinChecker      *BuiltinChecker
+	checkerArgs         []string
+	compileCache        *CompileCache
+
+	// tempDir keeps the actual outputs of failed cases
+	tempDir       string
+	failed        bool
+	checkerVolume *Volume
+	sourceVolume  *Volume
+
+	referenceLang   langs.Lang
+	referenceVolume *Volume
+}
+
+type JudgeOption func(*Judge) error
+
+func WithCheckerCompileFlags(flags ...string) JudgeOption {
+	return func(j *Judge) error {
+		if err := validateCompileFlags(flags); err != nil {
+			return err
+		}
+		j.checkerCompileFlags = flags
+		return nil
+	}
+}
+
+// WithCheckerArguments appends args to the arguments of checker.cpp after the file names, e.g. eps of testlib checkers
+func WithCheckerArguments(args ...string) JudgeOption {
+	return func(j *Judge) error {
+		if err := validateCheckerArguments(args); err != nil {
+			return err
+		}
+		j.checkerArgs = args
+		return nil
+	}
+}
+
+// WithBuiltinChecker uses the checker in the judge instead of checker.cpp, CompileChecker does nothing
+func WithBuiltinChecker(c BuiltinChecker) JudgeOption {
+	return func(j *Judge) error {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		j.builtinChecker = &c
+		return nil
+	}
+}
+
+// WithCompileCommand replaces the compile command of the language for the source, e.g. to pin the compiler of a problem
+func WithCompileCommand(command ...string) JudgeOption {
+	return func(j *Judge) error {
+		if err := validateCompileCommand(command); err != nil {
+			return err
+		}
+		j.compileCommand = command
+		return nil
+	}
+}
+
+// WithCompileFlags appends flags to the compile command of the source, after WithCompileCommand is applied
+func WithCompileFlags(flags ...string) JudgeOption {
+	return func(j *Judge) error {
+		if err := validateCompileFlags(flags); err != nil {
+			return err
+		}
+		j.compileFlags = flags
+		return nil
+	}
+}
+
+// WithCompileCache loads the compiled source from c if the same source is compiled before, and saves it otherwise
+func WithCompileCache(c *CompileCache) JudgeOption {
+	return func(j *Judge) error {
+		j.compileCache = c
+		return nil
+	}
+}
+
+// WithCaseResultCallback sets the function called by Run each time a test case is judged
+func WithCaseResultCallback(f func(idx int, result CaseResult) error) JudgeOption {
+	return func(j *Judge) error {
+		j.onCaseResult = f
+		return nil
+	}
+}
+
+// FailurePolicy decides the cases skipped by Run after a case is failed. The zero value runs all cases.
+type FailurePolicy struct {
+	StopAfterFailure bool
+	// ExtraCases is the number of cases run after the first failed case, e.g. to confirm TLE
+	ExtraCases int
+}
+
+func (p FailurePolicy) skip(idx, firstFailedIdx int) bool {
+	return p.StopAfterFailure && firstFailedIdx != -1 && idx > firstFailedIdx+p.ExtraCases
+}
+
+func WithFailurePolicy(p FailurePolicy) JudgeOption {
+	return func(j *Judge) error {
+		if p.ExtraCases < 0 {
+			return fmt.Errorf("invalid ExtraCases: %d", p.ExtraCases)
+		}
+		j.failurePolicy = p
+		return nil
+	}
+}
+
+// TimingPolicy decides the runs of the source on each case to reduce the noise of the time near the time limit.
+// The zero value runs the source once.
+type TimingPolicy struct {
+	// Runs is the max number of runs, the result of the fastest run is used
+	Runs int
+	// Margin is the ratio to the time limit, the source is run again only if the time is over (1 - Margin) * time limit
+	Margin float64
+}
+
+func (p TimingPolicy) rerun(timeLimit float64, best TaskResult, runs int) bool {
+	return runs < p.Runs && best.Time.Seconds() >= timeLimit*(1-p.Margin)
+}
+
+func WithTimingPolicy(p TimingPolicy) JudgeOption {
+	return func(j *Judge) error {
+		if p.Runs < 0 {
+			return fmt.Errorf("invalid Runs: %d", p.Runs)
+		}
+		if p.Margin < 0 || p.Margin > 1 {
+			return fmt.Errorf("invalid Margin: %v", p.Margin)
+		}
+		j.timingPolicy = p
+		return nil
+	}
+}
+
+// WithStackLimitMB sets the stack size limit of the source, 0 means unlimited.
+// The stack is a part of the memory, so it is clamped to the memory limit of the judge.
+func WithStackLimitMB(limitMB int) JudgeOption {
+	return func(j *Judge) error {
+		if limitMB < 0 {
+			return fmt.Errorf("invalid stack limit: %d MB", limitMB)
+		}
+		j.stackLimitKB = limitMB * 1024
+		return nil
+	}
+}
+
+// clampStackLimitKB returns the stack limit which is not larger than the memory limit, 0 (unlimited) is kept
+func clampStackLimitKB(stackLimitKB int, memoryLimitBytes int64) int {
+	if memoryLimitKB := int(memoryLimitBytes / 1024); stackLimitKB > memoryLimitKB {
+		return memoryLimitKB
+	}
+	return stackLimitKB
+}
+
+// WithSourceMemoryLimitMB sets the memory limit of the source instead of DEFAULT_MEMORY_LIMIT_MB
+func WithSourceMemoryLimitMB(limitMB int) JudgeOption {
+	return func(j *Judge) error {
+		if limitMB <= 0 {
+			return fmt.Errorf("invalid memory limit: %d MB", limitMB)
+		}
+		j.memoryLimitMB = limitMB
+		return nil
+	}
+}
+
+// WithSolutionUsageForChecker passes the time and memory used by the source to the checker
+// by the environment variables SOLUTION_TIME_MS and SOLUTION_MEMORY_BYTES.
+// SOLUTION_MEMORY_BYTES is -1 if the memory is not measured.
+func WithSolutionUsageForChecker() JudgeOption {
+	return func(j *Judge) error {
+		j.passUsageToChecker = true
+		return nil
+	}
+}
+
+// WithLabel sets the label used in the name of the temp dir and logs, e.g. the submission id
+func WithLabel(label string) JudgeOption {
+	return func(j *Judge) error {
+		j.label = label
+		return nil
+	}
+}
+
+// WithKeepTempDirOnFailure keeps the temp dir with the source and the actual outputs of failed cases for debugging
+func WithKeepTempDirOnFailure() JudgeOption {
+	return func(j *Judge) error {
+		j.keepTempDir = true
+		return nil
+	}
+}
+
+var labelRegex = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// NewJudgeForLangID creates a Judge with the language of langID, it fails if langs.GetLang doesn't know langID
+func NewJudgeForLangID(files storage.ProblemFiles, langID string, srcPath string, timeLimit float64, options ...JudgeOption) (*Judge, error) {
+	lang, ok := langs.GetLang(langID)
+	if !ok {
+		return nil, fmt.Errorf("unknown language %q", langID)
+	}
+	return NewJudge(files, lang, srcPath, timeLimit, options...)
+}
+
+// NewJudge creates a Judge, lang must be a language of langs.GetLang, use NewJudgeForLangID to look it up by the id
+func NewJudge(files storage.ProblemFiles, lang langs.Lang, srcPath string, timeLimit float64, options ...JudgeOption) (*Judge, error) {
+	if lang.ID == "" {
+		// the zero value returned by langs.GetLang for an unknown id
+		return nil, errors.New("language is not specified")
+	}
+	if _, ok := langs.GetLang(lang.ID); !ok {
+		return nil, fmt.Errorf("unknown language %q", lang.ID)
+	}
+	j := &Judge{
+		files:     files,
+		lang:      lang,
+		srcPath:   srcPath,
+		timeLimit: timeLimit,
+	}
+	for _, option := range options {
+		if err := option(j); err != nil {
+			return nil, err
+		}
+	}
+	if j.compileCommand != nil {
+		j.lang.Compile = j.compileCommand
+	}
+	j.lang.Compile = append(slices.Clone(j.lang.Compile), j.compileFlags...)
+	// the memory limit may be set after the stack limit
+	j.stackLimitKB = clampStackLimitKB(j.stackLimitKB, j.MemoryLimitBytes())
+
+	tempDir, err := os.MkdirTemp("", "judge-"+labelRegex.ReplaceAllString(j.label, "_")+"-")
+	if err != nil {
+		return nil, err
+	}
+	j.tempDir = tempDir
+	logger().Info("Create judge", "label", j.label, "tempDir", tempDir)
+	if j.keepTempDir {
+		if err := copyFile(srcPath, path.Join(tempDir, lang.Source)); err != nil {
+			os.RemoveAll(tempDir)
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+func (j *Judge) CompileChecker() (TaskResult, error) {
+	if j.builtinChecker != nil {
+		return TaskResult{}, nil
+	}
+	v, t, err := compileChecker(j.files, j.checkerCompileFlags...)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	j.removeVolume(&j.checkerVolume)
+	j.checkerVolume = &v
+	return t, nil
+}
+
+func (j *Judge) CompileSource() (TaskResult, error) {
+	v, t, err := compileWithCache(j.compileCache, j.files, j.srcPath, j.lang)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	j.removeVolume(&j.sourceVolume)
+	j.sourceVolume = &v
+	return t, nil
+}
+
+// TestCase runs the compiled source on a test case, CompileChecker and CompileSource must be called before
+func (j *Judge) TestCase(inFilePath, expectFilePath string) (CaseResult, error) {
+	var checker caseChecker
+	if j.builtinChecker != nil {
+		checker = *j.builtinChecker
+	} else if j.checkerVolume != nil {
+		checker = testlibChecker{volume: *j.checkerVolume, args: j.checkerArgs, passUsage: j.passUsageToChecker}
+	}
+	if checker == nil || j.sourceVolume == nil {
+		return CaseResult{}, errors.New("checker or source is not compiled")
+	}
+	sourceOptions := []TaskInfoOption{}
+	if j.stackLimitKB != 0 {
+		sourceOptions = append(sourceOptions, WithStackLimitKB(j.stackLimitKB))
+	}
+	if j.memoryLimitMB != 0 {
+		sourceOptions = append(sourceOptions, WithMemoryLimitMB(j.memoryLimitMB))
+	}
+	result, err := runTestCase(*j.sourceVolume, checker, j.lang, j.timeLimit, j.timingPolicy, inFilePath, expectFilePath, j.tempDir, sourceOptions...)
+	if err != nil || result.Status != "AC" {
+		j.failed = true
+	}
+	return result, err
+}
+
+// CompileReference compiles a trusted reference solution for TestCaseAgainstReference
+func (j *Judge) CompileReference(lang langs.Lang, srcPath string) (TaskResult, error) {
+	v, t, err := compile(j.files, srcPath, lang)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	j.removeVolume(&j.referenceVolume)
+	j.referenceLang = lang
+	j.referenceVolume = &v
+	return t, nil
+}
+
+// TestCaseAgainstReference runs the reference solution on the input and judges the source with its output as the expected output.
+// The status is RF (reference failed) if the reference solution gets TLE or RE, CompileReference must be called before.
+func (j *Judge) TestCaseAgainstReference(inFilePath string) (CaseResult, error) {
+	if j.referenceVolume == nil {
+		return CaseResult{}, errors.New("reference solution is not compiled")
+	}
+	inFilePath, removeInFile, err := decompressIfGzipped(inFilePath)
+	if err != nil {
+		return CaseResult{}, ioError(err)
+	}
+	defer removeInFile()
+
+	expectFilePath, r, err := runSource(*j.referenceVolume, j.referenceLang, j.timeLimit, inFilePath)
+	if err != nil {
+		return CaseResult{}, err
+	}
+	defer os.Remove(expectFilePath)
+	if r.TLE || r.ExitCode != 0 {
+		j.failed = true
+		return CaseResult{Status: "RF", Time: r.Time, Memory: r.Memory, TLE: r.TLE, Stderr: r.Stderr, CheckerOut: []byte{}}, nil
+	}
+
+	return j.TestCase(inFilePath, expectFilePath)
+}
+
+// TestCaseRepeated runs the same test case n times without recompiling, e.g. to find flaky verdicts
+func (j *Judge) TestCaseRepeated(inFilePath, expectFilePath string, n int) ([]CaseResult, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid repeat count: %d", n)
+	}
+	results := []CaseResult{}
+	for i := 0; i < n; i++ {
+		result, err := j.TestCase(inFilePath, expectFilePath)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Close removes the volumes of the compiled checker, source and reference, and the temp dir unless it is kept
+func (j *Judge) Close() error {
+	errs := []error{j.removeVolume(&j.checkerVolume), j.removeVolume(&j.sourceVolume), j.removeVolume(&j.referenceVolume)}
+	if j.tempDir != "" {
+		if j.keepTempDir && j.failed {
+			logger().Info("Keep temp dir of failed judge", "label", j.label, "tempDir", j.tempDir)
+		} else {
+			errs = append(errs, os.RemoveAll(j.tempDir))
+		}
+		j.tempDir = ""
+	}
+	return errors.Join(errs...)
+}
+
+func (j *Judge) removeVolume(v **Volume) error {
+	if *v == nil {
+		return nil
+	}
+	err := (*v).Remove()
+	if err != nil {
+		logger().Error("Volume remove failed", "err", err)
+	}
+	*v = nil
+	return err
+}
+
+type GenerateResult struct {
+	// Status is empty if the input is generated, otherwise GCE (compile error) or GE (runtime error)
+	Status string
+	// TaskResult is the result of the failed compile or the run of the generator
+	TaskResult TaskResult
+	// InFilePath is the generated input, which should be removed by the caller
+	InFilePath string
+}
+
+// GenerateInput compiles and runs the generator with args, e.g. a seed, and returns the generated input for TestCase.
+// The generator runs with GENERATOR_TIMEOUT and the default memory limit.
+func (j *Judge) GenerateInput(generatorPath string, args ...string) (GenerateResult, error) {
+	return generateInput(j.files, generatorPath, args...)
+}
+
+func generateInput(files storage.ProblemFiles, generatorPath string, args ...string) (GenerateResult, error) {
+	v, r, err := compile(files, generatorPath, langs.LANG_GENERATOR)
+	if err != nil {
+		return GenerateResult{}, err
+	}
+	defer v.Remove()
+	if r.ExitCode != 0 {
+		return GenerateResult{Status: "GCE", TaskResult: r}, nil
+	}
+
+	inFilePath, r, err := runGenerator(v, args...)
+	if err != nil {
+		return GenerateResult{}, err
+	}
+	if r.ExitCode != 0 || r.TLE {
+		os.Remove(inFilePath)
+		return GenerateResult{Status: "GE", TaskResult: r}, nil
+	}
+	return GenerateResult{TaskResult: r, InFilePath: inFilePath}, nil
+}
+
+type CompileCheckResult struct {
+	Checker TaskResult
+	Source  TaskResult
+}
+
+func (r CompileCheckResult) OK() bool {
+	return r.Checker.ExitCode == 0 && r.Source.ExitCode == 0
+}
+
+// CompileCheck compiles both of the checker and the source without test cases, e.g. to check the files of a problem before uploading test cases.
+// Unlike Run, the source is compiled even if the checker is failed to compile.
+func (j *Judge) CompileCheck() (CompileCheckResult, error) {
+	checkerResult, err := j.CompileChecker()
+	if err != nil {
+		return CompileCheckResult{}, err
+	}
+	sourceResult, err := j.CompileSource()
+	if err != nil {
+		return CompileCheckResult{}, err
+	}
+	return CompileCheckResult{Checker: checkerResult, Source: sourceResult}, nil
+}
+
+type TestCaseInput struct {
+	Name           string
+	InFilePath     string
+	ExpectFilePath string
+}
+
+// TestCaseInputs returns the inputs of the named test cases in files
+func TestCaseInputs(files storage.ProblemFiles, names []string) []TestCaseInput {
+	cases := []TestCaseInput{}
+	for _, name := range names {
+		cases = append(cases, TestCaseInput{
+			Name:           name,
+			InFilePath:     files.InFilePath(name),
+			ExpectFilePath: files.OutFilePath(name),
+		})
+	}
+	return cases
+}
+
+type JudgeResult struct {
+	// Status is ICE / CE if compile failed, otherwise the aggregated status of CaseResults
+	Status string
+	// CompileResult is the result of the failed compile or the source compile
+	CompileResult TaskResult
+	CaseResults   []CaseResult
+	Total         AggregateResult
+}
+
+func (r JudgeResult) CompileError() []byte {
+	if r.Status == "ICE" || r.Status == "CE" {
+		return r.CompileResult.Stderr
+	}
+	return []byte{}
+}
+
+// Run compiles the checker and the source, and judges the cases. The cases skipped by FailurePolicy have the status "Skipped".
+func (j *Judge) Run(cases []TestCaseInput) (_ JudgeResult, err error) {
+	defer func() {
+		if err != nil {
+			j.failed = true
+		}
+	}()
+	checkerResult, err := j.CompileChecker()
+	if err != nil {
+		return JudgeResult{}, err
+	}
+	if checkerResult.ExitCode != 0 {
+		j.failed = true
+		metricsRecorder.CountStatus("ICE")
+		return JudgeResult{Status: "ICE", CompileResult: checkerResult}, nil
+	}
+
+	sourceResult, err := j.CompileSource()
+	if err != nil {
+		return JudgeResult{}, err
+	}
+	if sourceResult.ExitCode != 0 {
+		j.failed = true
+		metricsRecorder.CountStatus("CE")
+		return JudgeResult{Status: "CE", CompileResult: sourceResult}, nil
+	}
+
+	results := []CaseResult{}
+	firstFailedIdx := -1
+	for idx, c := range cases {
+		var result CaseResult
+		if j.failurePolicy.skip(idx, firstFailedIdx) {
+			result = CaseResult{Status: "Skipped", Memory: -1, Stderr: []byte{}, CheckerOut: []byte{}}
+		} else {
+			result, err = j.TestCase(c.InFilePath, c.ExpectFilePath)
+			if err != nil {
+				return JudgeResult{}, err
+			}
+			if firstFailedIdx == -1 && result.Status != "AC" {
+				firstFailedIdx = idx
+			}
+		}
+		result.CaseName = c.Name
+		results = append(results, result)
+
+		if j.onCaseResult != nil {
+			if err := j.onCaseResult(idx, result); err != nil {
+				return JudgeResult{}, err
+			}
+		}
+	}
+
+	total := AggregateResultsWithLimits(results, j.TimeLimit(), j.MemoryLimitBytes())
+	metricsRecorder.CountStatus(total.Status)
+	return JudgeResult{
+		Status:        total.Status,
+		CompileResult: sourceResult,
+		CaseResults:   results,
+		Total:         total,
+	}, nil
+}
+
+// TimeLimit returns the time limit of each case
+func (j *Judge) TimeLimit() time.Duration {
+	return time.Duration(j.timeLimit * float64(time.Second))
+}
+
+// MemoryLimitBytes returns the memory limit of the source, the stack is a part of it
+func (j *Judge) MemoryLimitBytes() int64 {
+	if j.memoryLimitMB != 0 {
+		return int64(j.memoryLimitMB) * 1024 * 1024
+	}
+	return DEFAULT_MEMORY_LIMIT_MB * 1024 * 1024
+}
+
+// testlibPath is the path of testlib.h used instead of the one in problem files if not empty
+var testlibPath = os.Getenv("TESTLIB_PATH")
+
+// checkTestlib returns an error if testlib.h used for compiling checkers of dir is not found
+func checkTestlib(dir storage.ProblemFiles) error {
+	p := dir.TestlibPath()
+	if testlibPath != "" {
+		p = testlibPath
+	}
+	if _, err := os.Stat(p); err != nil {
+		return fmt.Errorf("testlib.h is not found: %w", err)
+	}
+	return nil
+}
+
+// compileChecker compiles checker.cpp, extraFlags are appended to the compile command
+func compileChecker(dir storage.ProblemFiles, extraFlags ...string) (Volume, TaskResult, error) {
+	if err := validateCompileFlags(extraFlags); err != nil {
+		return Volume{}, TaskResult{}, err
+	}
+	// missing testlib.h is not a compile error of the checker
+	if err := checkTestlib(dir); err != nil {
+		return Volume{}, TaskResult{}, err
+	}
+	l := langs.LANG_CHECKER
+	l.Compile = append(slices.Clone(l.Compile), extraFlags...)
+	extraFiles := []string{}
+	if testlibPath != "" {
+		extraFiles = append(extraFiles, testlibPath)
+	}
+	return compile(dir, dir.CheckerPath(), l, extraFiles...)
+}
+
+var compileFlagRegex = regexp.MustCompile(`^-[A-Za-z0-9_+=.,/:-]+$`)
+
+// validateCompileFlags checks that each flag is a single option without shell metacharacters.
+// Compile commands are not passed to a shell, but some of them are wrapped by "sh -c".
+func validateCompileFlags(flags []string) error {
+	for _, flag := range flags {
+		if !compileFlagRegex.MatchString(flag) {
+			return fmt.Errorf("invalid compile flag: %q", flag)
+		}
+	}
+	return nil
+}
+
+var compileArgRegex = regexp.MustCompile(`^[A-Za-z0-9_+=.,/:-]+$`)
+
+// validateCheckerArguments checks the arguments of the checker like validateCompileCommand, but they can be empty
+func validateCheckerArguments(args []string) error {
+	for _, arg := range args {
+		if !compileArgRegex.MatchString(arg) {
+			return fmt.Errorf("invalid checker argument: %q", arg)
+		}
+	}
+	return nil
+}
+
+// validateCompileCommand checks the command and its arguments like validateCompileFlags, arguments may be file names
+func validateCompileCommand(command []string) error {
+	if len(command) == 0 {
+		return errors.New("empty compile command")
+	}
+	for _, arg := range command {
+		if !compileArgRegex.MatchString(arg) {
+			return fmt.Errorf("invalid compile argument: %q", arg)
+		}
+	}
+	return nil
 }
 
 func compileVerifier(dir storage.ProblemFiles) (Volume, TaskResult, error) {
 	return compile(dir, dir.VerifierPath(), langs.LANG_VERIFIER)
 }
 
-func compileModelSolution(dir storage.ProblemFiles) (Volume, TaskResult, error) {
-	return compile(dir, dir.SolutionPath(), langs.LANG_MODEL_SOLUTION)
+func compileModelSolution(dir storage.ProblemFiles, lang langs.Lang) (Volume, TaskResult, error) {
+	return compile(dir, dir.SolutionPath(), lang)
 }
 
-func compile(dir storage.ProblemFiles, srcPath string, l langs.Lang) (v Volume, t TaskResult, err error) {
-	slog.Info("Compile", "lang", l.ID, "src", srcPath)
-
+// compile compiles srcPath with the include files of dir, extraFiles are also copied to the workdir
+// compileFilePaths returns the existing files copied with the source to compile
+func compileFilePaths(dir storage.ProblemFiles, l langs.Lang, extraFiles ...string) ([]string, error) {
 	paths := []string{}
 	for _, key := range l.AdditionalFiles {
 		paths = append(paths, dir.PublicFilePath(key))
 	}
 	if ps, err := dir.IncludeFilePaths(); err != nil {
-		return Volume{}, TaskResult{}, err
+		return nil, err
 	} else {
 		paths = append(paths, ps...)
 	}
+	paths = append(paths, extraFiles...)
+
+	existingPaths := []string{}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			existingPaths = append(existingPaths, p)
+		} else if errors.Is(err, os.ErrNotExist) {
+			logger().Debug("File is not found, skip", "path", p)
+		} else {
+			return nil, err
+		}
+	}
+	return existingPaths, nil
+}
+
+func compile(dir storage.ProblemFiles, srcPath string, l langs.Lang, extraFiles ...string) (v Volume, t TaskResult, err error) {
+	logger().Info("Compile", "lang", l.ID, "src", srcPath)
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			metricsRecorder.ObserveCompile(l.ID, time.Since(start))
+		}
+	}()
+
+	paths, err := compileFilePaths(dir, l, extraFiles...)
+	if err != nil {
+		return Volume{}, TaskResult{}, err
+	}
 
 	v, err = CreateVolume()
 	if err != nil {
@@ -76,7 +737,7 @@ func compile(dir storage.ProblemFiles, srcPath string, l langs.Lang) (v Volume,
 	defer func() {
 		if err != nil {
 			if err := v.Remove(); err != nil {
-				log.Println("Volume remove failed:", err)
+				logger().Error("Volume remove failed", "err", err)
 			}
 		}
 	}()
@@ -85,13 +746,7 @@ func compile(dir storage.ProblemFiles, srcPath string, l langs.Lang) (v Volume,
 		return
 	}
 	for _, p := range paths {
-		if _, err = os.Stat(p); err == nil {
-			if err = v.CopyFile(p, path.Base(p)); err != nil {
-				return
-			}
-		} else if errors.Is(err, os.ErrNotExist) {
-			log.Println(p, "is not found, skip")
-		} else {
+		if err = v.CopyFile(p, path.Base(p)); err != nil {
 			return
 		}
 	}
@@ -110,36 +765,116 @@ func compile(dir storage.ProblemFiles, srcPath string, l langs.Lang) (v Volume,
 	return
 }
 
-func runTestCase(sourceVolume, checkerVolume Volume, lang langs.Lang, timeLimit float64, inFilePath, expectFilePath string) (CaseResult, error) {
-	outFilePath, result, err := runSource(sourceVolume, lang, timeLimit, inFilePath)
+var (
+	// ErrSandbox is returned when docker fails to run a task, it may succeed on another judge server
+	ErrSandbox = errors.New("sandbox error")
+	// ErrIO is returned when the judge fails to operate local files
+	ErrIO = errors.New("io error")
+)
+
+// IsRetryable returns whether the error is caused by the judge server rather than the source, and the task may succeed on another judge server
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrSandbox)
+}
+
+func sandboxError(err error) error {
+	return fmt.Errorf("%w: %w", ErrSandbox, err)
+}
+
+func ioError(err error) error {
+	return fmt.Errorf("%w: %w", ErrIO, err)
+}
+
+// caseChecker checks the actual output of a case, the exit code of the result follows testlib (0: AC, 1: WA, 2: PE, 3: Fail)
+type caseChecker interface {
+	check(inFilePath, expectFilePath, actualFilePath string, sourceResult TaskResult) (TaskResult, error)
+}
+
+// testlibChecker runs the compiled checker.cpp
+type testlibChecker struct {
+	volume Volume
+	args   []string
+	// passUsage passes the usage of the source to the checker, see WithSolutionUsageForChecker
+	passUsage bool
+}
+
+func (c testlibChecker) check(inFilePath, expectFilePath, actualFilePath string, sourceResult TaskResult) (TaskResult, error) {
+	options := []TaskInfoOption{}
+	if c.passUsage {
+		options = append(options,
+			WithEnv("SOLUTION_TIME_MS", strconv.FormatInt(sourceResult.Time.Milliseconds(), 10)),
+			WithEnv("SOLUTION_MEMORY_BYTES", strconv.FormatInt(sourceResult.Memory, 10)),
+		)
+	}
+	result, err := runChecker(c.volume, inFilePath, expectFilePath, actualFilePath, c.args, options...)
+	if err != nil {
+		return TaskResult{}, sandboxError(err)
+	}
+	return result, nil
+}
+
+// runTestCase runs the source on a test case and checks the output.
+// Errors wrap ErrSandbox or ErrIO, and they are not caused by the source.
+// If outDir is not empty, the actual output of a failed case is moved to outDir for debugging.
+// sourceOptions are applied to the task of the source in addition to the default options.
+func runTestCase(sourceVolume Volume, checker caseChecker, lang langs.Lang, timeLimit float64, timing TimingPolicy, inFilePath, expectFilePath, outDir string, sourceOptions ...TaskInfoOption) (result CaseResult, err error) {
+	caseName := strings.TrimSuffix(strings.TrimSuffix(path.Base(inFilePath), ".gz"), ".in")
+	inFilePath, removeInFile, err := decompressIfGzipped(inFilePath)
+	if err != nil {
+		return CaseResult{}, ioError(err)
+	}
+	defer removeInFile()
+	expectFilePath, removeExpectFile, err := decompressIfGzipped(expectFilePath)
+	if err != nil {
+		return CaseResult{}, ioError(err)
+	}
+	defer removeExpectFile()
+
+	outFilePath, sourceResult, err := runSourceBest(sourceVolume, lang, timeLimit, timing, inFilePath, sourceOptions...)
 	if err != nil {
 		return CaseResult{}, err
 	}
-	defer os.Remove(outFilePath)
+	defer func() {
+		if outDir != "" && err == nil && result.Status != "AC" {
+			keepPath := path.Join(outDir, caseName+".actual.out")
+			if err := os.Rename(outFilePath, keepPath); err != nil {
+				logger().Error("Failed to keep actual output", "err", err)
+			}
+		}
+		os.Remove(outFilePath)
+	}()
 
-	baseResult := CaseResult{Time: result.Time, Memory: result.Memory, TLE: result.TLE, Stderr: result.Stderr, CheckerOut: []byte{}}
-	if result.TLE {
+	baseResult := CaseResult{Time: sourceResult.Time, Memory: sourceResult.Memory, TLE: sourceResult.TLE, TLEReason: sourceResult.TLEReason, Stderr: sourceResult.Stderr, CheckerOut: []byte{}, Stats: sourceResult.Stats}
+	if sourceResult.TLE {
 		//timeout
 		baseResult.Status = "TLE"
 		return baseResult, nil
 	}
 
-	if result.ExitCode != 0 {
+	if sourceResult.ExitCode != 0 {
 		//runtime error
-		baseResult.Status = "RE"
+		baseResult.Status = runtimeErrorStatus(sourceResult)
 		return baseResult, nil
 	}
 
-	checkerResult, err := runChecker(checkerVolume, inFilePath, expectFilePath, outFilePath)
+	checkerResult, err := checker.check(inFilePath, expectFilePath, outFilePath, sourceResult)
 	if err != nil {
 		return CaseResult{}, err
 	}
+	// the message of the checker is kept for all verdicts, including notes on AC
 	baseResult.CheckerOut = checkerResult.Stderr
+	metricsRecorder.ObserveChecker(checkerResult.Time)
 
 	if checkerResult.TLE {
 		baseResult.Status = "ITLE"
 	} else if checkerResult.ExitCode == 1 {
 		baseResult.Status = "WA"
+		// the diff is only for display, the verdict is kept even if it fails
+		if diff, err := firstTokenDiff(expectFilePath, outFilePath); err != nil {
+			logger().Error("Failed to find the first diff", "err", err)
+		} else {
+			baseResult.FirstDiff = diff
+		}
 	} else if checkerResult.ExitCode == 2 {
 		baseResult.Status = "PE"
 	} else if checkerResult.ExitCode == 3 {
@@ -152,44 +887,90 @@ func runTestCase(sourceVolume, checkerVolume Volume, lang langs.Lang, timeLimit
 	return baseResult, nil
 }
 
-func runSource(volume Volume, lang langs.Lang, timeLimit float64, inFilePath string) (string, TaskResult, error) {
-	caseVolume, err := CreateVolume()
+// runSourceBest runs the source until timing doesn't need more runs, and returns the output and the result of the fastest run
+func runSourceBest(volume Volume, lang langs.Lang, timeLimit float64, timing TimingPolicy, inFilePath string, options ...TaskInfoOption) (string, TaskResult, error) {
+	bestPath, best, err := runSource(volume, lang, timeLimit, inFilePath, options...)
 	if err != nil {
 		return "", TaskResult{}, err
 	}
+	metricsRecorder.ObserveRun(lang.ID, best.Time)
+	for runs := 1; timing.rerun(timeLimit, best, runs); runs++ {
+		logger().Debug("Run source again", "runs", runs, "time", best.Time)
+		outPath, result, err := runSource(volume, lang, timeLimit, inFilePath, options...)
+		if err != nil {
+			os.Remove(bestPath)
+			return "", TaskResult{}, err
+		}
+		metricsRecorder.ObserveRun(lang.ID, result.Time)
+		if result.Time < best.Time {
+			os.Remove(bestPath)
+			bestPath, best = outPath, result
+		} else {
+			os.Remove(outPath)
+		}
+	}
+	return bestPath, best, nil
+}
+
+// runtimeErrorStatus returns MLE for OOM kills, "RE (signal N)" for other signals, and RE otherwise.
+// The source is PID 1 of the container, so the exit code is 128+N if it is killed by signal N.
+func runtimeErrorStatus(result TaskResult) string {
+	if result.OOMKilled {
+		return "MLE"
+	}
+	if 128 < result.ExitCode && result.ExitCode < 128+65 {
+		return fmt.Sprintf("RE (signal %d)", result.ExitCode-128)
+	}
+	return "RE"
+}
+
+// runSource runs the source with the input, and returns the path of the output file, which should be removed by the caller.
+// Each run has a fresh caseVolume, so nothing written by the previous run, e.g. a part of the output or symlinks, is left.
+func runSource(volume Volume, lang langs.Lang, timeLimit float64, inFilePath string, options ...TaskInfoOption) (_ string, _ TaskResult, err error) {
+	caseVolume, err := CreateVolume()
+	if err != nil {
+		return "", TaskResult{}, sandboxError(err)
+	}
 	defer func() {
 		if err := caseVolume.Remove(); err != nil {
-			log.Println("Failed to remove caseVolume:", err)
+			logger().Error("Failed to remove caseVolume", "err", err)
 		}
 	}()
 
-	if err := caseVolume.CopyFile(inFilePath, "input.in"); err != nil {
-		return "", TaskResult{}, err
+	inputOptions, err := caseFileOptions(&caseVolume, "/casedir", inFilePath, "input.in")
+	if err != nil {
+		return "", TaskResult{}, sandboxError(err)
 	}
 
 	// TODO: make volume read only
-	taskInfo, err := NewTaskInfo(lang.ImageName, append(
-		DEFAULT_OPTIONS,
+	taskOptions := append(append(
+		slices.Clone(DEFAULT_OPTIONS),
 		WithArguments(append([]string{"library-checker-init", "/casedir/input.in", "/casedir/actual.out"}, lang.Exec...)...),
 		WithWorkDir("/workdir"),
 		WithVolume(&volume, "/workdir"),
 		WithVolume(&caseVolume, "/casedir"),
 		WithTimeout(time.Duration(timeLimit*1000*1000*1000)*time.Nanosecond),
-	)...)
+	), inputOptions...)
+	taskInfo, err := NewTaskInfo(lang.ImageName, append(taskOptions, options...)...)
 	if err != nil {
-		return "", TaskResult{}, err
+		return "", TaskResult{}, sandboxError(err)
 	}
 
 	result, err := taskInfo.Run()
 	if err != nil {
-		return "", TaskResult{}, err
+		return "", TaskResult{}, sandboxError(err)
 	}
 
-	outFile, err := os.CreateTemp("", "")
+	outFile, err := createMountableTemp()
 	if err != nil {
-		return "", TaskResult{}, err
+		return "", TaskResult{}, ioError(err)
 	}
 	defer outFile.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(outFile.Name())
+		}
+	}()
 
 	// TODO: find faster way to copy actual.out
 	genOutputFileTaskInfo, err := NewTaskInfo("ubuntu", append(
@@ -200,35 +981,54 @@ func runSource(volume Volume, lang langs.Lang, timeLimit float64, inFilePath str
 		WithStdout(outFile),
 	)...)
 	if err != nil {
-		return "", TaskResult{}, err
+		return "", TaskResult{}, sandboxError(err)
 	}
 
 	if _, err := genOutputFileTaskInfo.Run(); err != nil {
-		return "", TaskResult{}, err
+		return "", TaskResult{}, sandboxError(err)
 	}
 
 	return outFile.Name(), result, err
 }
 
-func runChecker(volume Volume, inFilePath, expectFilePath, actualFilePath string) (TaskResult, error) {
-	if err := volume.CopyFile(inFilePath, "input.in"); err != nil {
-		return TaskResult{}, err
-	}
-	if err := volume.CopyFile(expectFilePath, "expect.out"); err != nil {
+// runChecker runs the checker on volume, args are appended to the arguments of testlib. The test case files are provided by caseFileOptions,
+// so large expected outputs are not duplicated for each case if the docker daemon is local.
+// Each run has a fresh working directory, which no source has mounted, and volume is mounted as read only,
+// so scratch files of the checker don't affect other cases.
+func runChecker(volume Volume, inFilePath, expectFilePath, actualFilePath string, args []string, options ...TaskInfoOption) (TaskResult, error) {
+	workVolume, err := CreateVolume()
+	if err != nil {
 		return TaskResult{}, err
 	}
-	if err := volume.CopyFile(actualFilePath, "actual.out"); err != nil {
-		return TaskResult{}, err
+	defer func() {
+		if err := workVolume.Remove(); err != nil {
+			logger().Error("Failed to remove workVolume", "err", err)
+		}
+	}()
+
+	fileOptions := []TaskInfoOption{}
+	for _, file := range []struct{ hostPath, name string }{
+		{inFilePath, "input.in"},
+		{expectFilePath, "expect.out"},
+		{actualFilePath, "actual.out"},
+	} {
+		o, err := caseFileOptions(&workVolume, "/workdir", file.hostPath, file.name)
+		if err != nil {
+			return TaskResult{}, err
+		}
+		fileOptions = append(fileOptions, o...)
 	}
 
-	// TODO: make volume read only?
-	checkerTaskInfo, err := NewTaskInfo(langs.LANG_CHECKER.ImageName, append(
-		DEFAULT_OPTIONS,
-		WithArguments(langs.LANG_CHECKER.Exec...),
+	exec := slices.Clone(langs.LANG_CHECKER.Exec)
+	exec[0] = path.Join("/checker", path.Base(exec[0]))
+	checkerTaskInfo, err := NewTaskInfo(langs.LANG_CHECKER.ImageName, append(append(append(
+		slices.Clone(DEFAULT_OPTIONS),
+		WithArguments(append(exec, args...)...),
 		WithWorkDir("/workdir"),
 		WithTimeout(CHECKER_TIMEOUT),
-		WithVolume(&volume, "/workdir"),
-	)...)
+		WithVolume(&workVolume, "/workdir"),
+		WithReadOnlyVolume(&volume, "/checker"),
+	), fileOptions...), options...)...)
 	if err != nil {
 		return TaskResult{}, err
 	}
@@ -236,18 +1036,57 @@ func runChecker(volume Volume, inFilePath, expectFilePath, actualFilePath string
 	return checkerTaskInfo.Run()
 }
 
-func runGenerator(v Volume) (string, TaskResult, error) {
-	outFile, err := os.CreateTemp("", "")
+// caseFileOptions provides the host file as name in volume, which is mounted to dir.
+// The file is bind mounted as read only if the docker daemon is local, it saves the copy of large files.
+// Otherwise it is copied into volume because the daemon can't access files of the host.
+func caseFileOptions(volume *Volume, dir, hostPath, name string) ([]TaskInfoOption, error) {
+	if bindMountable {
+		return []TaskInfoOption{WithReadOnlyBind(hostPath, path.Join(dir, name))}, nil
+	}
+	if err := volume.CopyFile(hostPath, name); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// createMountableTemp creates a temporary file which is readable in containers, os.CreateTemp creates it with 0600
+func createMountableTemp() (*os.File, error) {
+	f, err := os.CreateTemp("", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// runGenerator returns the path of the generated file, which should be removed by the caller.
+// args are passed to the generator, the default arguments of LANG_GENERATOR are used if empty.
+func runGenerator(v Volume, args ...string) (_ string, _ TaskResult, err error) {
+	exec := langs.LANG_GENERATOR.Exec
+	if len(args) != 0 {
+		exec = append([]string{exec[0]}, args...)
+	}
+
+	outFile, err := createMountableTemp()
 	if err != nil {
 		return "", TaskResult{}, err
 	}
 	defer outFile.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(outFile.Name())
+		}
+	}()
 
 	ti, err := NewTaskInfo(langs.LANG_GENERATOR.ImageName, append(
 		DEFAULT_OPTIONS,
-		WithArguments(langs.LANG_GENERATOR.Exec...),
+		WithArguments(exec...),
 		WithWorkDir("/workdir"),
-		WithTimeout(VERIFIER_TIMEOUT),
+		WithTimeout(GENERATOR_TIMEOUT),
 		WithVolume(&v, "/workdir"),
 		WithStdout(outFile),
 	)...)
@@ -261,3 +1100,67 @@ func runGenerator(v Volume) (string, TaskResult, error) {
 	}
 	return outFile.Name(), result, nil
 }
+
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// decompressIfGzipped returns the path of the decompressed file if filePath has the .gz suffix, otherwise filePath itself.
+// The content is not sniffed, a plain output can start with the gzip magic bytes.
+// The file is decompressed by streaming into a temporary file, which is removed by the returned function.
+func decompressIfGzipped(filePath string) (string, func(), error) {
+	noop := func() {}
+
+	if !strings.HasSuffix(filePath, ".gz") {
+		// a missing file is reported here like a compressed one
+		if _, err := os.Stat(filePath); err != nil {
+			return "", noop, err
+		}
+		return filePath, noop, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", noop, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", noop, err
+	}
+	defer gr.Close()
+
+	outFile, err := createMountableTemp()
+	if err != nil {
+		return "", noop, err
+	}
+	remove := func() {
+		if err := os.Remove(outFile.Name()); err != nil {
+			logger().Error("Failed to remove decompressed file", "err", err)
+		}
+	}
+	if _, err := io.Copy(outFile, gr); err != nil {
+		outFile.Close()
+		remove()
+		return "", noop, err
+	}
+	if err := outFile.Close(); err != nil {
+		remove()
+		return "", noop, err
+	}
+	return outFile.Name(), remove, nil
+}
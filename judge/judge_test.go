@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"embed"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"path"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/yosupo06/library-checker-judge/database"
 	"github.com/yosupo06/library-checker-judge/langs"
 	"github.com/yosupo06/library-checker-judge/storage"
 )
@@ -30,7 +39,7 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func prepareProblemFiles(t *testing.T, inFilePath, outFilePath string) storage.ProblemFiles {
+func prepareProblemFiles(t testing.TB, inFilePath, outFilePath string) storage.ProblemFiles {
 	tempDir, err := os.MkdirTemp("", "")
 	if err != nil {
 		t.Fatal("Failed to create tempDir: ", tempDir)
@@ -97,7 +106,7 @@ func testAplusB(t *testing.T, langID, srcName, inFilePath, outFilePath, expected
 	}
 	t.Cleanup(func() { sourceVolume.Remove() })
 
-	result, err := runTestCase(sourceVolume, checkerVolume, lang, 2.0, files.InFilePath(DUMMY_CASE_NAME), files.OutFilePath(DUMMY_CASE_NAME))
+	result, err := runTestCase(sourceVolume, testlibChecker{volume: checkerVolume}, lang, 2.0, TimingPolicy{}, files.InFilePath(DUMMY_CASE_NAME), files.OutFilePath(DUMMY_CASE_NAME), "")
 	if err != nil {
 		t.Fatal("Error to eval testCase", err)
 	}
@@ -106,6 +115,92 @@ func testAplusB(t *testing.T, langID, srcName, inFilePath, outFilePath, expected
 	if result.Status != expectedStatus {
 		t.Fatal("Error Status", result, string(result.Stderr), string(result.CheckerOut))
 	}
+	if (expectedStatus == "AC" || expectedStatus == "WA") && len(result.CheckerOut) == 0 {
+		t.Fatal("CheckerOut is empty", result)
+	}
+}
+
+// BenchmarkRunSourceLargeInput runs A + B with a 100MB input, e.g. to compare copying and bind mounting the input
+func BenchmarkRunSourceLargeInput(b *testing.B) {
+	files := prepareProblemFiles(b, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	src, err := sources.Open(path.Join(APLUSB_DIR, "ac.cpp"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer src.Close()
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		b.Fatal("Unknown lang cpp")
+	}
+	sourceVolume, sourceResult, err := compile(files, toRealFile(src, lang.Source, b), lang)
+	if err != nil || sourceResult.ExitCode != 0 {
+		b.Fatal("Error CompileSource", err)
+	}
+	b.Cleanup(func() { sourceVolume.Remove() })
+
+	// A + B ignores the trailing spaces
+	inFilePath := path.Join(b.TempDir(), "large.in")
+	if err := os.WriteFile(inFilePath, append([]byte("1 2\n"), bytes.Repeat([]byte(" "), 100*1024*1024)...), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		outFilePath, result, err := runSource(sourceVolume, lang, 10.0, inFilePath)
+		if err != nil || result.ExitCode != 0 {
+			b.Fatal("Error runSource", err, result)
+		}
+		os.Remove(outFilePath)
+	}
+}
+
+func TestRunTestCaseTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	files := prepareProblemFiles(t, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	src, err := sources.Open(path.Join(APLUSB_DIR, "ac.cpp"))
+	if err != nil {
+		t.Fatal("Failed: Source", err)
+	}
+	defer src.Close()
+
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	srcFile := toRealFile(src, lang.Source, t)
+
+	checkerVolume, checkerResult, err := compileChecker(files)
+	if err != nil || checkerResult.ExitCode != 0 {
+		t.Fatal("Error CompileChecker", err)
+	}
+	t.Cleanup(func() { checkerVolume.Remove() })
+
+	sourceVolume, sourceResult, err := compile(files, srcFile, lang)
+	if err != nil || sourceResult.ExitCode != 0 {
+		t.Fatal("Error CompileSource", err)
+	}
+	t.Cleanup(func() { sourceVolume.Remove() })
+
+	before, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := runTestCase(sourceVolume, testlibChecker{volume: checkerVolume}, lang, 2.0, TimingPolicy{}, files.InFilePath(DUMMY_CASE_NAME), files.OutFilePath(DUMMY_CASE_NAME), ""); err != nil {
+			t.Fatal("Error to eval testCase", err)
+		}
+	}
+	after, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != len(after) {
+		t.Fatal("temp files are leaked", len(before), len(after))
+	}
 }
 
 func testAplusBAC(t *testing.T, langID, srcName string) {
@@ -207,3 +302,735 @@ func TestAplusBCE(t *testing.T) {
 	}
 	t.Cleanup(func() { volume.Remove() })
 }
+
+func TestValidateCompileFlags(t *testing.T) {
+	for _, flags := range [][]string{
+		nil,
+		{"-std=c++20"},
+		{"-I/opt/include", "-DEVAL", "-O2", "-Wl,--stack=1024"},
+	} {
+		if err := validateCompileFlags(flags); err != nil {
+			t.Fatal(flags, err)
+		}
+	}
+	for _, flags := range [][]string{
+		{""},
+		{"-O2 -g"},
+		{"main.cpp"},
+		{"-DX=$(rm -rf /)"},
+		{"-O2;", "ls"},
+	} {
+		if err := validateCompileFlags(flags); err == nil {
+			t.Fatal("invalid flags are accepted: ", flags)
+		}
+	}
+}
+
+func TestCompileOverrides(t *testing.T) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	defaultCompile := slices.Clone(lang.Compile)
+
+	for _, test := range []struct {
+		options  []JudgeOption
+		expected []string
+	}{
+		{nil, defaultCompile},
+		{[]JudgeOption{WithCompileFlags("-O0")}, append(slices.Clone(defaultCompile), "-O0")},
+		{[]JudgeOption{WithCompileCommand("g++", "-std=c++17", "-o", "main", "main.cpp")}, []string{"g++", "-std=c++17", "-o", "main", "main.cpp"}},
+		{[]JudgeOption{WithCompileFlags("-g"), WithCompileCommand("g++", "-o", "main", "main.cpp")}, []string{"g++", "-o", "main", "main.cpp", "-g"}},
+	} {
+		j, err := NewJudge(storage.ProblemFiles{}, lang, "main.cpp", 2.0, test.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(j.lang.Compile, test.expected) {
+			t.Fatal("Error compile command", j.lang.Compile, test.expected)
+		}
+	}
+	if !reflect.DeepEqual(lang.Compile, defaultCompile) {
+		t.Fatal("compile command of the language is modified", lang.Compile)
+	}
+
+	for _, option := range []JudgeOption{
+		WithCompileCommand(),
+		WithCompileCommand("sh", "-c", "g++ main.cpp"),
+		WithCompileCommand("g++", "main.cpp;", "ls"),
+		WithCompileFlags("main.cpp"),
+	} {
+		if _, err := NewJudge(storage.ProblemFiles{}, lang, "main.cpp", 2.0, option); err == nil {
+			t.Fatal("invalid compile command is accepted")
+		}
+	}
+}
+
+func TestValidateCheckerArguments(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"1e-9"},
+		{"--eps=0.000001", "strict"},
+	} {
+		if err := validateCheckerArguments(args); err != nil {
+			t.Fatal(args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{""},
+		{"1e-9 strict"},
+		{"$(ls)"},
+		{"a;b"},
+	} {
+		if err := validateCheckerArguments(args); err == nil {
+			t.Fatal("invalid arguments are accepted: ", args)
+		}
+	}
+}
+
+func TestDecompressIfGzipped(t *testing.T) {
+	content := []byte("1 2\n")
+
+	plainPath := path.Join(t.TempDir(), "plain.in")
+	if err := os.WriteFile(plainPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := path.Join(t.TempDir(), "compressed.in.gz")
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gzPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the suffix is checked, the content of a plain file may look like gzip
+	magicPath := path.Join(t.TempDir(), "magic.in")
+	if err := os.WriteFile(magicPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	actualPath, remove, err := decompressIfGzipped(magicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+	if actualPath != magicPath {
+		t.Fatal("file without .gz suffix is decompressed", actualPath)
+	}
+
+	for _, filePath := range []string{plainPath, gzPath} {
+		actualPath, remove, err := decompressIfGzipped(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := os.ReadFile(actualPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, content) {
+			t.Fatal("invalid content", filePath, actual)
+		}
+		remove()
+		if filePath == gzPath {
+			if _, err := os.Stat(actualPath); !errors.Is(err, os.ErrNotExist) {
+				t.Fatal("decompressed file is not removed", err)
+			}
+		}
+	}
+}
+
+func TestJudgeRun(t *testing.T) {
+	files := prepareProblemFiles(t, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+
+	for _, test := range []struct {
+		srcName        string
+		expectedStatus string
+	}{
+		{"ac.cpp", "AC"},
+		{"wa.cpp", "WA"},
+		{"ce.cpp", "CE"},
+	} {
+		src, err := sources.Open(path.Join(APLUSB_DIR, test.srcName))
+		if err != nil {
+			t.Fatal("Failed: Source", err)
+		}
+		srcFile := toRealFile(src, lang.Source, t)
+		src.Close()
+		defer os.Remove(srcFile)
+
+		called := 0
+		j, err := NewJudge(files, lang, srcFile, 2.0, WithCaseResultCallback(func(idx int, result CaseResult) error {
+			called++
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := j.Run(TestCaseInputs(files, []string{DUMMY_CASE_NAME}))
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatal("Error to run judge", err)
+		}
+		if result.Status != test.expectedStatus {
+			t.Fatal("Error Status", test.srcName, result)
+		}
+		if test.expectedStatus == "CE" {
+			if called != 0 || len(result.CaseResults) != 0 || len(result.CompileError()) == 0 {
+				t.Fatal("Invalid CE result", result)
+			}
+		} else if called != 1 || len(result.CaseResults) != 1 || result.CaseResults[0].CaseName != DUMMY_CASE_NAME {
+			t.Fatal("Invalid case results", called, result)
+		}
+	}
+}
+
+func TestJudgeGenerateInput(t *testing.T) {
+	files := prepareProblemFiles(t, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	src, err := sources.Open(path.Join(APLUSB_DIR, "ac.cpp"))
+	if err != nil {
+		t.Fatal("Failed: Source", err)
+	}
+	srcFile := toRealFile(src, lang.Source, t)
+	src.Close()
+	defer os.Remove(srcFile)
+
+	gen, err := sources.Open(path.Join(APLUSB_DIR, "gen.cpp"))
+	if err != nil {
+		t.Fatal("Failed: Generator", err)
+	}
+	genFile := toRealFile(gen, langs.LANG_GENERATOR.Source, t)
+	gen.Close()
+	defer os.Remove(genFile)
+
+	j, err := NewJudge(files, lang, srcFile, 2.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	result, err := j.GenerateInput(genFile, "3")
+	if err != nil {
+		t.Fatal("Error to generate input", err)
+	}
+	if result.Status != "" {
+		t.Fatal("Error Status", result)
+	}
+	defer os.Remove(result.InFilePath)
+
+	in, err := os.ReadFile(result.InFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(in) != "3 4\n" {
+		t.Fatalf("Invalid input: %q", in)
+	}
+}
+
+func TestNewJudgeUnknownLang(t *testing.T) {
+	lang, _ := langs.GetLang("foo")
+	if _, err := NewJudge(storage.ProblemFiles{}, lang, "main.foo", 2.0); err == nil {
+		t.Fatal("NewJudge accepts unknown language")
+	}
+	if _, err := NewJudge(storage.ProblemFiles{}, langs.Lang{ID: "foo", Compile: []string{"foo"}, Exec: []string{"foo"}}, "main.foo", 2.0); err == nil || !strings.Contains(err.Error(), `"foo"`) {
+		t.Fatal("NewJudge accepts unknown language", err)
+	}
+	if _, err := NewJudgeForLangID(storage.ProblemFiles{}, "foo", "main.foo", 2.0); err == nil || !strings.Contains(err.Error(), `"foo"`) {
+		t.Fatal("requested id is not in the error", err)
+	}
+
+	j, err := NewJudgeForLangID(storage.ProblemFiles{}, "cpp", "main.cpp", 2.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+}
+
+func TestCheckTestlib(t *testing.T) {
+	dir := storage.ProblemFiles{PublicFiles: t.TempDir()}
+	if err := checkTestlib(dir); err == nil {
+		t.Fatal("missing testlib.h is not detected")
+	}
+
+	if err := os.MkdirAll(path.Dir(dir.TestlibPath()), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir.TestlibPath(), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTestlib(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuntimeErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		result   TaskResult
+		expected string
+	}{
+		{TaskResult{ExitCode: 1}, "RE"},
+		{TaskResult{ExitCode: 128}, "RE"},
+		{TaskResult{ExitCode: 139}, "RE (signal 11)"},
+		{TaskResult{ExitCode: 137}, "RE (signal 9)"},
+		{TaskResult{ExitCode: 137, OOMKilled: true}, "MLE"},
+		{TaskResult{ExitCode: 255}, "RE"},
+	} {
+		if status := runtimeErrorStatus(test.result); status != test.expected {
+			t.Fatal("Error Status", test.result, status)
+		}
+	}
+}
+
+func TestJudgeCompileCheck(t *testing.T) {
+	files := prepareProblemFiles(t, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+
+	for _, test := range []struct {
+		srcName string
+		ok      bool
+	}{
+		{"ac.cpp", true},
+		{"ce.cpp", false},
+	} {
+		src, err := sources.Open(path.Join(APLUSB_DIR, test.srcName))
+		if err != nil {
+			t.Fatal("Failed: Source", err)
+		}
+		srcFile := toRealFile(src, lang.Source, t)
+		src.Close()
+		defer os.Remove(srcFile)
+
+		j, err := NewJudge(files, lang, srcFile, 2.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := j.CompileCheck()
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatal("Error to compile", err)
+		}
+		if result.Checker.ExitCode != 0 || result.OK() != test.ok {
+			t.Fatal("Error CompileCheck", test.srcName, result)
+		}
+	}
+}
+
+func TestJudgeTestCaseAgainstReference(t *testing.T) {
+	files := prepareProblemFiles(t, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	openSource := func(srcName string) string {
+		src, err := sources.Open(path.Join(APLUSB_DIR, srcName))
+		if err != nil {
+			t.Fatal("Failed: Source", err)
+		}
+		defer src.Close()
+		return toRealFile(src, lang.Source, t)
+	}
+
+	for _, test := range []struct {
+		srcName        string
+		refName        string
+		expectedStatus string
+	}{
+		{"ac.cpp", "ac.cpp", "AC"},
+		{"wa.cpp", "ac.cpp", "WA"},
+		{"ac.cpp", "re.cpp", "RF"},
+	} {
+		j, err := NewJudge(files, lang, openSource(test.srcName), 2.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := j.TestCaseAgainstReference(files.InFilePath(DUMMY_CASE_NAME)); err == nil {
+			t.Fatal("TestCaseAgainstReference succeeded without reference")
+		}
+		result, err := func() (CaseResult, error) {
+			if _, err := j.CompileChecker(); err != nil {
+				return CaseResult{}, err
+			}
+			if _, err := j.CompileSource(); err != nil {
+				return CaseResult{}, err
+			}
+			if _, err := j.CompileReference(lang, openSource(test.refName)); err != nil {
+				return CaseResult{}, err
+			}
+			return j.TestCaseAgainstReference(files.InFilePath(DUMMY_CASE_NAME))
+		}()
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatal("Error to judge", err)
+		}
+		if result.Status != test.expectedStatus {
+			t.Fatal("Error Status", test.srcName, test.refName, result)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	testlib, err := sources.Open(TESTLIB_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultTestlibPath := testlibPath
+	t.Cleanup(func() { testlibPath = defaultTestlibPath })
+	testlibPath = toRealFile(testlib, "testlib.h", t)
+	testlib.Close()
+
+	report, err := SelfTest()
+	if err != nil {
+		t.Fatal("Error to run self test", err)
+	}
+	if !report.OK() || len(report.Cases) != 2 {
+		t.Fatal("Self test failed", report)
+	}
+}
+
+func TestAggregateResults(t *testing.T) {
+	result := AggregateResults([]CaseResult{
+		{CaseName: "example_00", Status: "AC", Time: 10 * time.Millisecond, Memory: 300},
+		{CaseName: "random_00", Status: "AC", Time: 30 * time.Millisecond, Memory: 100},
+		{CaseName: "random_01", Status: "WA", Time: 30 * time.Millisecond, Memory: 500},
+		{CaseName: "random_02", Status: "AC", Time: 20 * time.Millisecond, Memory: 200},
+	})
+	if result.Status != "WA" || result.Time != 30*time.Millisecond || result.Memory != 500 {
+		t.Fatal("Error AggregateResults", result)
+	}
+	if result.MaxTimeCase != "random_00" || result.MaxMemoryCase != "random_01" {
+		t.Fatal("Error max cases", result.MaxTimeCase, result.MaxMemoryCase)
+	}
+
+	empty := AggregateResults(nil)
+	if empty.Status != "AC" || empty.Memory != -1 || empty.MaxTimeCase != "" {
+		t.Fatal("Error AggregateResults of no case", empty)
+	}
+
+	limited := AggregateResultsWithLimits([]CaseResult{
+		{CaseName: "example_00", Status: "AC", Time: 1900 * time.Millisecond, Memory: 300},
+		{CaseName: "random_00", Status: "AC", Time: 500 * time.Millisecond, Memory: 900},
+		{CaseName: "random_01", Status: "Skipped", Memory: -1},
+	}, 2*time.Second, 1000)
+	if limited.TimeHeadroom != 100*time.Millisecond || limited.MemoryHeadroom != 100 {
+		t.Fatal("Error headroom", limited.TimeHeadroom, limited.MemoryHeadroom)
+	}
+	tle := AggregateResultsWithLimits([]CaseResult{
+		{CaseName: "example_00", Status: "TLE", Time: 2100 * time.Millisecond, Memory: 300},
+	}, 2*time.Second, 1000)
+	if tle.TimeHeadroom != -100*time.Millisecond {
+		t.Fatal("Error headroom of TLE", tle.TimeHeadroom)
+	}
+
+	if !limited.BarelyPassed(2*time.Second, 1000) {
+		t.Fatal("AC with 5% headroom is not barely passed")
+	}
+	if tle.BarelyPassed(2*time.Second, 1000) {
+		t.Fatal("TLE is barely passed")
+	}
+	roomy := AggregateResultsWithLimits([]CaseResult{
+		{CaseName: "example_00", Status: "AC", Time: 1000 * time.Millisecond, Memory: 500},
+	}, 2*time.Second, 1000)
+	if roomy.BarelyPassed(2*time.Second, 1000) {
+		t.Fatal("AC with 50% headroom is barely passed")
+	}
+}
+
+func TestRemainingTestCases(t *testing.T) {
+	cases := []string{"example_00", "example_01", "random_00", "random_01"}
+	results := []database.SubmissionTestcaseResult{
+		{Testcase: "random_00", Status: "AC"},
+		{Testcase: "example_00", Status: "WA"},
+	}
+	if remaining := RemainingTestCases(cases, results); !reflect.DeepEqual(remaining, []string{"example_01", "random_01"}) {
+		t.Fatal("Error RemainingTestCases", remaining)
+	}
+	if remaining := RemainingTestCases(cases, nil); !reflect.DeepEqual(remaining, cases) {
+		t.Fatal("Error RemainingTestCases without results", remaining)
+	}
+
+	result := AggregateResults([]CaseResult{
+		caseResultOf(database.SubmissionTestcaseResult{Testcase: "example_00", Status: "AC", Time: 1500, Memory: 100}),
+		{CaseName: "example_01", Status: "AC", Time: time.Second, Memory: 200},
+	})
+	if result.Status != "AC" || result.Time != 1500*time.Millisecond || result.MaxTimeCase != "example_00" || result.Memory != 200 {
+		t.Fatal("Error AggregateResults with saved results", result)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	statuses := []string{"AC", "TLE", "AC", "TLE", "AC", "AC"}
+	for _, test := range []struct {
+		policy   FailurePolicy
+		expected []bool
+	}{
+		{FailurePolicy{}, []bool{false, false, false, false, false, false}},
+		{FailurePolicy{StopAfterFailure: true}, []bool{false, false, true, true, true, true}},
+		{FailurePolicy{StopAfterFailure: true, ExtraCases: 2}, []bool{false, false, false, false, true, true}},
+	} {
+		firstFailedIdx := -1
+		for idx, status := range statuses {
+			skipped := test.policy.skip(idx, firstFailedIdx)
+			if skipped != test.expected[idx] {
+				t.Fatal("Error skip", test.policy, idx, skipped)
+			}
+			if !skipped && firstFailedIdx == -1 && status != "AC" {
+				firstFailedIdx = idx
+			}
+		}
+	}
+
+	result := AggregateResults([]CaseResult{
+		{CaseName: "example_00", Status: "TLE", Time: 2 * time.Second, Memory: 100},
+		{CaseName: "example_01", Status: "Skipped", Memory: -1},
+	})
+	if result.Status != "TLE" || result.MaxTimeCase != "example_00" {
+		t.Fatal("Error AggregateResults with skipped cases", result)
+	}
+}
+
+func TestTimingPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy   TimingPolicy
+		time     time.Duration
+		runs     int
+		expected bool
+	}{
+		{TimingPolicy{}, 2 * time.Second, 1, false},
+		{TimingPolicy{Runs: 3, Margin: 0.1}, 1 * time.Second, 1, false},
+		{TimingPolicy{Runs: 3, Margin: 0.1}, 1900 * time.Millisecond, 1, true},
+		{TimingPolicy{Runs: 3, Margin: 0.1}, 2 * time.Second, 2, true},
+		{TimingPolicy{Runs: 3, Margin: 0.1}, 2 * time.Second, 3, false},
+	} {
+		if rerun := test.policy.rerun(2.0, TaskResult{Time: test.time}, test.runs); rerun != test.expected {
+			t.Fatal("Error rerun", test.policy, test.time, test.runs, rerun)
+		}
+	}
+
+	for _, p := range []TimingPolicy{{Runs: -1}, {Runs: 3, Margin: -0.1}, {Runs: 3, Margin: 1.5}} {
+		if _, err := NewJudge(storage.ProblemFiles{}, langs.ModelSolution(), "main.cpp", 2.0, WithTimingPolicy(p)); err == nil {
+			t.Fatal("invalid policy is accepted", p)
+		}
+	}
+}
+
+func TestNewJudgeForProblem(t *testing.T) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+
+	j, err := NewJudgeForProblem(database.Problem{Name: "aplusb", Timelimit: 2500}, storage.Info{}, storage.ProblemFiles{}, lang, "main.cpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.timeLimit != 2.5 {
+		t.Fatal("Error timeLimit", j.timeLimit)
+	}
+	if j.MemoryLimitBytes() != DEFAULT_MEMORY_LIMIT_MB*1024*1024 {
+		t.Fatal("Error MemoryLimitBytes", j.MemoryLimitBytes())
+	}
+
+	j2, err := NewJudgeForProblem(database.Problem{Name: "aplusb", Timelimit: 2500}, storage.Info{MemoryLimitMB: 512}, storage.ProblemFiles{}, lang, "main.cpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j2.Close()
+	if j2.MemoryLimitBytes() != 512*1024*1024 {
+		t.Fatal("memory limit of info is not used", j2.MemoryLimitBytes())
+	}
+
+	if _, err := NewJudgeForProblem(database.Problem{Name: "aplusb"}, storage.Info{}, storage.ProblemFiles{}, lang, "main.cpp"); err == nil {
+		t.Fatal("NewJudgeForProblem accepts a problem without time limit")
+	}
+}
+
+func TestJudgeTempDir(t *testing.T) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	srcFile := toRealFile(bytes.NewBufferString("int main() {}"), lang.Source, t)
+	defer os.Remove(srcFile)
+
+	j, err := NewJudge(storage.ProblemFiles{}, lang, srcFile, 2.0, WithLabel("submission-1/case"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path.Base(j.tempDir), "judge-submission-1_case-") {
+		t.Fatal("Error tempDir name", j.tempDir)
+	}
+	tempDir := j.tempDir
+	j.failed = true
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tempDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("tempDir is not removed", err)
+	}
+
+	j, err = NewJudge(storage.ProblemFiles{}, lang, srcFile, 2.0, WithKeepTempDirOnFailure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDir = j.tempDir
+	defer os.RemoveAll(tempDir)
+	j.failed = true
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(tempDir, lang.Source)); err != nil {
+		t.Fatal("source is not kept", err)
+	}
+}
+
+func TestJudgeTestCaseRepeated(t *testing.T) {
+	files := prepareProblemFiles(t, SAMPLE_IN_PATH, SAMPLE_OUT_PATH)
+
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	src, err := sources.Open(path.Join(APLUSB_DIR, "ac.cpp"))
+	if err != nil {
+		t.Fatal("Failed: Source", err)
+	}
+	srcFile := toRealFile(src, lang.Source, t)
+	src.Close()
+	defer os.Remove(srcFile)
+
+	j, err := NewJudge(files, lang, srcFile, 2.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if result, err := j.CompileCheck(); err != nil || !result.OK() {
+		t.Fatal("Error to compile", err, result)
+	}
+
+	if _, err := j.TestCaseRepeated(files.InFilePath(DUMMY_CASE_NAME), files.OutFilePath(DUMMY_CASE_NAME), 0); err == nil {
+		t.Fatal("invalid repeat count is accepted")
+	}
+	results, err := j.TestCaseRepeated(files.InFilePath(DUMMY_CASE_NAME), files.OutFilePath(DUMMY_CASE_NAME), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatal("Error results", results)
+	}
+	for _, result := range results {
+		if result.Status != "AC" {
+			t.Fatal("Error Status", result)
+		}
+	}
+}
+
+func TestWithStackLimitMB(t *testing.T) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+
+	j, err := NewJudge(storage.ProblemFiles{}, lang, "main.cpp", 2.0, WithStackLimitMB(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.stackLimitKB != 256*1024 {
+		t.Fatal("Error stackLimitKB", j.stackLimitKB)
+	}
+
+	if _, err := NewJudge(storage.ProblemFiles{}, lang, "main.cpp", 2.0, WithStackLimitMB(-1)); err == nil {
+		t.Fatal("invalid stack limit is accepted")
+	}
+
+	// the stack limit is clamped to the memory limit which applies, regardless of the order of the options
+	for _, test := range []struct {
+		options  []JudgeOption
+		expected int
+	}{
+		{[]JudgeOption{WithStackLimitMB(DEFAULT_MEMORY_LIMIT_MB + 1)}, DEFAULT_MEMORY_LIMIT_MB * 1024},
+		{[]JudgeOption{WithStackLimitMB(512), WithSourceMemoryLimitMB(256)}, 256 * 1024},
+		{[]JudgeOption{WithSourceMemoryLimitMB(2048), WithStackLimitMB(1536)}, 1536 * 1024},
+		{[]JudgeOption{WithSourceMemoryLimitMB(256)}, 0},
+	} {
+		j, err := NewJudge(storage.ProblemFiles{}, lang, "main.cpp", 2.0, test.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Close()
+		if j.stackLimitKB != test.expected {
+			t.Fatal("Error stackLimitKB", j.stackLimitKB, "!=", test.expected)
+		}
+	}
+}
+
+func TestRunTestCaseIOError(t *testing.T) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		t.Fatal("Unknown lang cpp")
+	}
+	notFound := path.Join(t.TempDir(), "not_found.in")
+	_, err := runTestCase(Volume{}, testlibChecker{}, lang, 2.0, TimingPolicy{}, notFound, notFound, "")
+	if !errors.Is(err, ErrIO) || errors.Is(err, ErrSandbox) {
+		t.Fatal("error is not ErrIO", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("original error is not wrapped", err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if !IsRetryable(fmt.Errorf("failed to judge: %w", sandboxError(errors.New("docker is dead")))) {
+		t.Fatal("sandbox error is not retryable")
+	}
+	for _, err := range []error{ioError(os.ErrNotExist), errors.New("unknown"), nil} {
+		if IsRetryable(err) {
+			t.Fatal("error is retryable", err)
+		}
+	}
+}
+
+func TestCreateMountableTemp(t *testing.T) {
+	f, err := createMountableTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatal("temporary file is not readable in containers:", info.Mode().Perm())
+	}
+}
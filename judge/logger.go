@@ -0,0 +1,18 @@
+package main
+
+import "log/slog"
+
+// judgeLogger is the logger used in the judge, nil means slog.Default()
+var judgeLogger *slog.Logger
+
+// SetLogger sets the logger used in the judge
+func SetLogger(l *slog.Logger) {
+	judgeLogger = l
+}
+
+func logger() *slog.Logger {
+	if judgeLogger == nil {
+		return slog.Default()
+	}
+	return judgeLogger
+}
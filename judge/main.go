@@ -2,39 +2,106 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/yosupo06/library-checker-judge/database"
+	"github.com/yosupo06/library-checker-judge/langs"
 	"github.com/yosupo06/library-checker-judge/storage"
 )
 
 const POOLING_PERIOD = 3 * time.Second
 
+var debug = flag.Bool("debug", false, "output debug logs")
+var keepTempDir = flag.Bool("keep-temp-dir", false, "keep temp dirs of failed judges for debugging")
+var timeRuns = flag.Int("time-runs", 1, "max number of runs of each case, the fastest one is used")
+var timeMargin = flag.Float64("time-margin", 0.1, "ratio to the time limit, cases are run again only if the time is over (1 - ratio) * time limit")
+var compileCacheDir = flag.String("compile-cache-dir", "", "directory to cache compiled submissions, disabled if empty")
+var compileCacheEntries = flag.Int("compile-cache-entries", DEFAULT_COMPILE_CACHE_ENTRIES, "max number of cached compiled submissions")
+var selfTest = flag.Bool("self-test", false, "run the self test before pooling, exit if it fails")
+
 func main() {
 	flag.Parse()
 
+	if *debug {
+		SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+
+	if p := os.Getenv("LANGS_TOML"); p != "" {
+		if err := langs.LoadLangs(p); err != nil {
+			logger().Error("Failed to load langs", "path", p, "err", err)
+			os.Exit(1)
+		}
+		go reloadLangsOnSIGHUP(p)
+	}
+
+	if err := checkDocker(); err != nil {
+		logger().Error("Failed to find docker", "err", err)
+		os.Exit(1)
+	}
+
+	if testlibPath != "" {
+		if _, err := os.Stat(testlibPath); err != nil {
+			logger().Error("TESTLIB_PATH is not found", "path", testlibPath, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if *compileCacheDir != "" {
+		c, err := NewCompileCache(*compileCacheDir, *compileCacheEntries)
+		if err != nil {
+			logger().Error("Failed to create compile cache", "dir", *compileCacheDir, "err", err)
+			os.Exit(1)
+		}
+		compileCache = c
+	}
+
+	if *selfTest {
+		report, err := SelfTest()
+		if err != nil {
+			logger().Error("Failed to run self test", "err", err)
+			os.Exit(1)
+		}
+		if !report.OK() {
+			logger().Error("Self test failed", "problems", report.Problems)
+			os.Exit(1)
+		}
+		logger().Info("Self test passed")
+	}
+
 	// connect db
 	db := database.Connect(database.GetDSNFromEnv(), false)
 
 	storageClient, err := storage.Connect(storage.GetConfigFromEnv())
 	if err != nil {
-		slog.Error("Failed to connect to storage", "err", err)
+		logger().Error("Failed to connect to storage", "err", err)
 		os.Exit(1)
 	}
 	downloader, err := storage.NewTestCaseDownloader(storageClient)
 	if err != nil {
-		slog.Error("Failed to create TestCaseDownloader", "err", err)
+		logger().Error("Failed to create TestCaseDownloader", "err", err)
 		os.Exit(1)
 	}
 	defer downloader.Close()
+	sourceStore := storage.NewSourceStore(storageClient)
 
-	slog.Info("Start pooling")
+	judgeName := getJudgeName()
+
+	logger().Info("Start pooling", "judgeName", judgeName)
 	for {
-		taskID, taskData, err := database.PopTask(db)
+		// don't take tasks while the node is unhealthy, they would be judged as IE
+		if health := HealthStatus(); !health.OK() {
+			logger().Warn("Judge is unhealthy", "problems", health.Problems)
+			time.Sleep(POOLING_PERIOD)
+			continue
+		}
+		taskID, taskData, err := database.PopTask(db, judgeName)
 		if err != nil {
-			slog.Error("PopJudgeTask failed", "err", err)
+			logger().Error("PopJudgeTask failed", "err", err)
 			time.Sleep(POOLING_PERIOD)
 			continue
 		}
@@ -43,19 +110,59 @@ func main() {
 			continue
 		}
 
-		slog.Info("Start task", "ID", taskID)
+		logger().Info("Start task", "ID", taskID)
 		switch taskData.TaskType {
 		case database.JUDGE_SUBMISSION:
-			if err := execSubmissionTask(db, downloader, taskID, taskData.Submission); err != nil {
-				slog.Error("failed to judge Submission", "err", err)
-				continue
+			err = execSubmissionTask(db, downloader, sourceStore, judgeName, taskID, taskData.Submission)
+			if err != nil {
+				logger().Error("failed to judge Submission", "err", err)
 			}
 		case database.JUDGE_HACK:
-			if err := execHackTask(db, downloader, taskID, taskData.Hack); err != nil {
-				slog.Error("failed to judge Hack", "err", err)
-				continue
+			err = execHackTask(db, downloader, sourceStore, judgeName, taskID, taskData.Hack)
+			if err != nil {
+				logger().Error("failed to judge Hack", "err", err)
+			}
+		}
+		if err != nil {
+			if IsRetryable(err) {
+				logger().Info("Release task to retry", "ID", taskID)
+				if err := database.ReleaseTask(db, taskID, judgeName); err != nil {
+					logger().Error("ReleaseTask failed", "err", err)
+				}
+				// give other judges a chance to take it
+				time.Sleep(POOLING_PERIOD)
 			}
+			continue
 		}
 		database.FinishTask(db, taskID)
 	}
 }
+
+// reloadLangsOnSIGHUP reloads langs from path each time SIGHUP is received, the current langs are kept if it fails
+func reloadLangsOnSIGHUP(path string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := langs.LoadLangs(path); err != nil {
+			logger().Error("Failed to reload langs", "path", path, "err", err)
+			continue
+		}
+		logger().Info("Reload langs", "path", path)
+	}
+}
+
+// compileCache is the cache of compiled submissions, nil if it is disabled
+var compileCache *CompileCache
+
+func timingPolicy() TimingPolicy {
+	return TimingPolicy{Runs: *timeRuns, Margin: *timeMargin}
+}
+
+// getJudgeName returns the name to hold tasks, it is unique for each judge process
+func getJudgeName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// MetricsRecorder receives timings and verdicts of the judge, e.g. to export them as Prometheus metrics.
+// Instrumentation is disabled unless a recorder is set by SetMetricsRecorder.
+type MetricsRecorder interface {
+	// ObserveCompile is called with the wall-clock time of each compile
+	ObserveCompile(lang string, d time.Duration)
+	// ObserveRun is called with the used time of the source on each test case
+	ObserveRun(lang string, d time.Duration)
+	// ObserveChecker is called with the used time of the checker on each test case
+	ObserveChecker(d time.Duration)
+	// CountStatus is called with the final status of each Judge.Run
+	CountStatus(status string)
+}
+
+type nopMetricsRecorder struct{}
+
+func (nopMetricsRecorder) ObserveCompile(string, time.Duration) {}
+func (nopMetricsRecorder) ObserveRun(string, time.Duration)     {}
+func (nopMetricsRecorder) ObserveChecker(time.Duration)         {}
+func (nopMetricsRecorder) CountStatus(string)                   {}
+
+var metricsRecorder MetricsRecorder = nopMetricsRecorder{}
+
+// SetMetricsRecorder sets the recorder of the judge metrics, nil disables instrumentation
+func SetMetricsRecorder(m MetricsRecorder) {
+	if m == nil {
+		m = nopMetricsRecorder{}
+	}
+	metricsRecorder = m
+}
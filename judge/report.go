@@ -0,0 +1,61 @@
+package main
+
+// JudgeReport is the JSON representation of JudgeResult for callers of the judge.
+// The field names are the column names of the submissions and submission_testcase_results tables,
+// except for the fields which are not stored in the tables.
+type JudgeReport struct {
+	Status       string `json:"status"`
+	CompileError string `json:"compile_error"`
+	MaxTime      int32  `json:"max_time"` // ms, -1 if no case is judged
+	MaxMemory    int64  `json:"max_memory"`
+	// names of the first case with the max time / memory, they are not stored in the tables
+	MaxTimeCase   string `json:"max_time_case"`
+	MaxMemoryCase string `json:"max_memory_case"`
+	// the min headroom (limit - peak) of the cases, 0 if no case is judged. They are not stored in the tables.
+	TimeHeadroom    int32        `json:"time_headroom"` // ms
+	MemoryHeadroom  int64        `json:"memory_headroom"`
+	TestcaseResults []CaseReport `json:"testcase_results"`
+}
+
+type CaseReport struct {
+	Testcase   string `json:"testcase"`
+	Status     string `json:"status"`
+	Time       int32  `json:"time"` // ms
+	Memory     int64  `json:"memory"`
+	Stderr     string `json:"stderr"`
+	CheckerOut string `json:"checker_out"`
+	// they are not stored in the tables
+	TLEReason TLEReason  `json:"tle_reason"`           // empty unless TLE
+	FirstDiff *TokenDiff `json:"first_diff,omitempty"` // only for WA
+}
+
+func (r JudgeResult) Report() JudgeReport {
+	report := JudgeReport{
+		Status:          r.Status,
+		CompileError:    string(r.CompileError()),
+		MaxTime:         -1,
+		MaxMemory:       -1,
+		TestcaseResults: []CaseReport{},
+	}
+	if len(r.CaseResults) != 0 {
+		report.MaxTime = int32(r.Total.Time.Milliseconds())
+		report.MaxMemory = r.Total.Memory
+		report.MaxTimeCase = r.Total.MaxTimeCase
+		report.MaxMemoryCase = r.Total.MaxMemoryCase
+		report.TimeHeadroom = int32(r.Total.TimeHeadroom.Milliseconds())
+		report.MemoryHeadroom = r.Total.MemoryHeadroom
+	}
+	for _, c := range r.CaseResults {
+		report.TestcaseResults = append(report.TestcaseResults, CaseReport{
+			Testcase:   c.CaseName,
+			Status:     c.Status,
+			Time:       int32(c.Time.Milliseconds()),
+			Memory:     c.Memory,
+			Stderr:     string(c.Stderr),
+			CheckerOut: string(c.CheckerOut),
+			TLEReason:  c.TLEReason,
+			FirstDiff:  c.FirstDiff,
+		})
+	}
+	return report
+}
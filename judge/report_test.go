@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJudgeReport(t *testing.T) {
+	results := []CaseResult{
+		{CaseName: "example_00", Status: "AC", Time: 12 * time.Millisecond, Memory: 100, Stderr: []byte{}, CheckerOut: []byte("ok")},
+		{CaseName: "random_00", Status: "WA", Time: 34 * time.Millisecond, Memory: 200, Stderr: []byte("debug"), CheckerOut: []byte("differ"),
+			FirstDiff: &TokenDiff{Index: 1, Expected: "3", Actual: "4", Before: []string{"1"}, ExpectedAfter: []string{}, ActualAfter: []string{}}},
+		{CaseName: "random_01", Status: "TLE", Time: 20 * time.Millisecond, Memory: 50, Stderr: []byte{}, CheckerOut: []byte{}, TLEReason: TLE_CPU_TIME},
+	}
+	result := JudgeResult{
+		Status:      "TLE",
+		CaseResults: results,
+		Total:       AggregateResultsWithLimits(results, time.Second, 1000),
+	}
+	data, err := json.Marshal(result.Report())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"status":"TLE","compile_error":"","max_time":34,"max_memory":200,"max_time_case":"random_00","max_memory_case":"random_00","time_headroom":966,"memory_headroom":800,"testcase_results":[` +
+		`{"testcase":"example_00","status":"AC","time":12,"memory":100,"stderr":"","checker_out":"ok","tle_reason":""},` +
+		`{"testcase":"random_00","status":"WA","time":34,"memory":200,"stderr":"debug","checker_out":"differ","tle_reason":"",` +
+		`"first_diff":{"index":1,"expected":"3","actual":"4","before":["1"],"expected_after":[],"actual_after":[]}},` +
+		`{"testcase":"random_01","status":"TLE","time":20,"memory":50,"stderr":"","checker_out":"","tle_reason":"cpu"}]}`
+	if string(data) != expected {
+		t.Fatal("Error JSON", string(data))
+	}
+
+	data, err = json.Marshal(JudgeResult{Status: "CE", CompileResult: TaskResult{Stderr: []byte("error")}}.Report())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"status":"CE","compile_error":"error","max_time":-1,"max_memory":-1,"max_time_case":"","max_memory_case":"","time_headroom":0,"memory_headroom":0,"testcase_results":[]}`
+	if string(data) != expected {
+		t.Fatal("Error JSON", string(data))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/yosupo06/library-checker-judge/langs"
+	"github.com/yosupo06/library-checker-judge/storage"
+)
+
+// selfTestFiles is a tiny A + B problem with a correct and a TLE solution
+//
+//go:embed selftest
+var selfTestFiles embed.FS
+
+const SELF_TEST_CASE_NAME = "sample"
+
+type SelfTestReport struct {
+	// Cases are the results of the correct solution and the TLE solution, zero if it is failed to compile
+	Cases []CaseResult
+	// Problems are the descriptions of unexpected results, empty if the judge works
+	Problems []string
+}
+
+func (r SelfTestReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// SelfTest judges a correct solution and a TLE solution of A + B with the C++ compiler,
+// to check that the sandbox, the compilers and testlib.h (TESTLIB_PATH) work before judging submissions.
+// An error is returned if the test can't be run, e.g. docker is not available.
+func SelfTest() (SelfTestReport, error) {
+	lang, ok := langs.GetLang("cpp")
+	if !ok {
+		return SelfTestReport{}, errors.New("unknown language \"cpp\"")
+	}
+
+	tempDir, err := os.MkdirTemp("", "judge-selftest-")
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := storage.ProblemFiles{
+		PublicFiles: tempDir,
+		TestCases:   tempDir,
+	}
+	for _, f := range []struct {
+		src string
+		dst string
+	}{
+		{"checker.cpp", files.CheckerPath()},
+		{"sample.in", files.InFilePath(SELF_TEST_CASE_NAME)},
+		{"sample.out", files.OutFilePath(SELF_TEST_CASE_NAME)},
+		{"ac.cpp", path.Join(tempDir, "src", "ac", lang.Source)},
+		{"tle.cpp", path.Join(tempDir, "src", "tle", lang.Source)},
+	} {
+		data, err := selfTestFiles.ReadFile(path.Join("selftest", f.src))
+		if err != nil {
+			return SelfTestReport{}, err
+		}
+		if err := os.MkdirAll(path.Dir(f.dst), os.ModePerm); err != nil {
+			return SelfTestReport{}, err
+		}
+		if err := os.WriteFile(f.dst, data, 0644); err != nil {
+			return SelfTestReport{}, err
+		}
+	}
+	if err := os.MkdirAll(files.PublicFilePath("common"), os.ModePerm); err != nil {
+		return SelfTestReport{}, err
+	}
+
+	report := SelfTestReport{}
+	for _, test := range []struct {
+		name           string
+		expectedStatus string
+	}{
+		{"ac", "AC"},
+		{"tle", "TLE"},
+	} {
+		result, problem, err := selfTestCase(files, lang, path.Join(tempDir, "src", test.name, lang.Source), test.expectedStatus)
+		if err != nil {
+			return SelfTestReport{}, err
+		}
+		report.Cases = append(report.Cases, result)
+		if problem != "" {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %s", test.name, problem))
+		}
+	}
+	return report, nil
+}
+
+// selfTestCase judges the source on the sample case, and returns the description of the problem if the result is unexpected
+func selfTestCase(files storage.ProblemFiles, lang langs.Lang, srcPath, expectedStatus string) (CaseResult, string, error) {
+	j, err := NewJudge(files, lang, srcPath, 1.0, WithLabel("selftest"))
+	if err != nil {
+		return CaseResult{}, "", err
+	}
+	defer j.Close()
+
+	compileResult, err := j.CompileCheck()
+	if err != nil {
+		return CaseResult{}, "", err
+	}
+	if compileResult.Checker.ExitCode != 0 {
+		return CaseResult{}, fmt.Sprintf("failed to compile checker: %s", compileResult.Checker.Stderr), nil
+	}
+	if compileResult.Source.ExitCode != 0 {
+		return CaseResult{}, fmt.Sprintf("failed to compile source: %s", compileResult.Source.Stderr), nil
+	}
+
+	result, err := j.TestCase(files.InFilePath(SELF_TEST_CASE_NAME), files.OutFilePath(SELF_TEST_CASE_NAME))
+	if err != nil {
+		return CaseResult{}, "", err
+	}
+	result.CaseName = SELF_TEST_CASE_NAME
+	if result.Status != expectedStatus {
+		return result, fmt.Sprintf("status is %s, expected %s", result.Status, expectedStatus), nil
+	}
+	return result, "", nil
+}
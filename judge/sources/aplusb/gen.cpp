@@ -0,0 +1,8 @@
+#include <cstdio>
+#include <cstdlib>
+
+int main(int argc, char* argv[]) {
+    int seed = argc > 1 ? atoi(argv[1]) : 0;
+    printf("%d %d\n", seed, seed + 1);
+    return 0;
+}
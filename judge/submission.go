@@ -1,10 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log/slog"
 	"os"
 	"path"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -13,17 +14,21 @@ import (
 	"github.com/yosupo06/library-checker-judge/storage"
 )
 
-func execSubmissionTask(db *gorm.DB, downloader storage.TestCaseDownloader, taskID int32, subID int32) error {
-	slog.Info("Start to judge submission", "taskID", taskID, "submissionID", subID)
+func execSubmissionTask(db *gorm.DB, downloader storage.TestCaseDownloader, sourceStore database.SourceStore, judgeName string, taskID int32, subID int32) error {
+	logger().Info("Start to judge submission", "taskID", taskID, "submissionID", subID)
 
 	s, err := database.FetchSubmission(db, subID)
 	if err != nil {
 		return err
 	}
+	source, err := database.FetchSubmissionSource(db, sourceStore, subID)
+	if err != nil {
+		return err
+	}
 
 	lang, ok := langs.GetLang(s.Lang)
 	if !ok {
-		return fmt.Errorf("unknown language: %v", s.Lang)
+		return fmt.Errorf("unknown language %q", s.Lang)
 	}
 
 	problem := storage.Problem{
@@ -36,19 +41,26 @@ func execSubmissionTask(db *gorm.DB, downloader storage.TestCaseDownloader, task
 		return err
 	}
 	data := SubmissionTaskData{
-		db:     db,
-		taskID: taskID,
-		files:  files,
-		s:      s,
-		lang:   lang,
+		db:        db,
+		judgeName: judgeName,
+		taskID:    taskID,
+		files:     files,
+		s:         s,
+		source:    source,
+		lang:      lang,
 	}
 
 	if err := data.init(); err != nil {
 		return err
 	}
 	if err := data.judge(); err != nil {
-		if err := data.updateSubmissionStatus("IE"); err != nil {
-			slog.Error("Deep error", "err", err)
+		// the submission waits for another judge if the error is retryable
+		status := "IE"
+		if IsRetryable(err) {
+			status = "WJ"
+		}
+		if err2 := data.updateSubmissionStatus(status); err2 != nil {
+			return errors.Join(err, fmt.Errorf("failed to update the status to %s: %w", status, err2))
 		}
 		return err
 	}
@@ -56,162 +68,291 @@ func execSubmissionTask(db *gorm.DB, downloader storage.TestCaseDownloader, task
 	return nil
 }
 
+// NewJudgeForProblem creates a Judge with the time limit of the problem record and the memory limit of info.
+// The memory limit is not stored in the record, DEFAULT_MEMORY_LIMIT_MB is used if info doesn't have it.
+func NewJudgeForProblem(p database.Problem, info storage.Info, files storage.ProblemFiles, lang langs.Lang, srcPath string, options ...JudgeOption) (*Judge, error) {
+	if p.Timelimit <= 0 {
+		return nil, fmt.Errorf("invalid time limit of %v: %d", p.Name, p.Timelimit)
+	}
+	if info.MemoryLimitMB != 0 {
+		options = append([]JudgeOption{WithSourceMemoryLimitMB(info.MemoryLimitMB)}, options...)
+	}
+	return NewJudge(files, lang, srcPath, float64(p.Timelimit)/1000, options...)
+}
+
 type SubmissionTaskData struct {
-	db     *gorm.DB
-	taskID int32
-	files  storage.ProblemFiles
-	s      database.Submission
-	lang   langs.Lang
+	db        *gorm.DB
+	judgeName string
+	taskID    int32
+	files     storage.ProblemFiles
+	s         database.Submission
+	source    string
+	lang      langs.Lang
 }
 
+// init prepares the submission for judging.
+// The results of an interrupted judge, i.e. retried with WJ or crashed while judging, are kept to resume it
+// if the test cases are not updated. Otherwise the results are cleared.
+// Only a finished submission is rejudged, restarting an interrupted judge doesn't increment RejudgeCount.
 func (data *SubmissionTaskData) init() error {
+	crashed := database.IsJudgingStatus(data.s.Status)
+	interrupted := data.s.Status == "WJ" || crashed
+	resume := interrupted && data.s.TestCasesVersion == data.s.Problem.TestCasesVersion
+	if resume {
+		// PrevStatus is kept, it is the status before the interrupted judge
+		logger().Info("Resume judging", "submissionID", data.s.ID)
+	} else if interrupted {
+		if err := database.ClearTestcaseResult(data.db, data.s.ID); err != nil {
+			return err
+		}
+	} else {
+		// the old results are cleared with the status, a crash doesn't leave them with the new status
+		if err := database.BeginRejudge(data.db, data.s.ID, data.s.Version, "-", data.taskID, data.judgeName); err != nil {
+			return err
+		}
+		data.s.RejudgeCount++
+		data.s.Version++
+	}
+	data.s.JudgedBy = data.judgeName
 	data.s.MaxTime = -1
 	data.s.MaxMemory = -1
-	data.s.PrevStatus = data.s.Status
+	// PrevStatus of a crashed judge is also kept
+	if !resume && !crashed {
+		data.s.PrevStatus = data.s.Status
+	}
 	data.s.Status = "-"
 	data.s.TestCasesVersion = data.s.Problem.TestCasesVersion
 	data.s.CompileError = []byte{}
-	if err := data.updateSubmission(); err != nil {
-		return err
-	}
-	if err := database.ClearTestcaseResult(data.db, data.s.ID); err != nil {
-		return err
-	}
-	return nil
+	return data.updateSubmission()
 }
 
 func (data *SubmissionTaskData) judge() error {
-	slog.Info("Fetch data")
+	logger().Info("Fetch data")
 	if err := data.updateSubmissionStatus("Fetching"); err != nil {
 		return err
 	}
 
-	slog.Info("Compile checker")
+	info, err := storage.ParseInfo(data.files.InfoTomlPath())
+	if err != nil {
+		return err
+	}
+
 	if err := data.updateSubmissionStatus("Compiling"); err != nil {
 		return err
 	}
-	checkerVolume, taskResult, err := compileChecker(data.files)
+	sourceDir, srcPath, err := data.writeSource()
 	if err != nil {
 		return err
 	}
-	defer checkerVolume.Remove()
-	if taskResult.ExitCode != 0 {
-		data.s.Status = "ICE"
-		data.s.CompileError = taskResult.Stderr
-		return data.updateSubmission()
-	}
+	defer os.RemoveAll(sourceDir)
 
-	sourceVolume, taskResult, err := data.compileSource()
+	// the cases judged before the interruption are skipped, their results are saved by WithCaseResultCallback
+	doneResults, err := database.FetchTestcaseResults(data.db, data.s.ID)
 	if err != nil {
 		return err
 	}
-	defer sourceVolume.Remove()
-	if taskResult.ExitCode != 0 {
-		data.s.Status = "CE"
-		data.s.CompileError = taskResult.Stderr
-		return data.updateSubmission()
+	testCaseNum := len(info.TestCaseNames())
+	testCases := RemainingTestCases(info.TestCaseNames(), doneResults)
+	doneNum := testCaseNum - len(testCases)
+	options := []JudgeOption{
+		WithLabel(fmt.Sprintf("submission-%d", data.s.ID)),
+		WithCheckerCompileFlags(info.CheckerCompileFlags...),
+		WithCheckerArguments(info.CheckerArguments...),
+		WithStackLimitMB(info.StackLimitMB),
+		WithTimingPolicy(timingPolicy()),
+		WithCaseResultCallback(func(idx int, result CaseResult) error {
+			if err := database.SaveTestcaseResult(data.db, database.SubmissionTestcaseResult{
+				Submission: data.s.ID,
+				Testcase:   result.CaseName,
+				Status:     result.Status,
+				Time:       int32(result.Time.Milliseconds()),
+				Memory:     result.Memory,
+				Stderr:     result.Stderr,
+				CheckerOut: result.CheckerOut,
+			}); err != nil {
+				return err
+			}
+			return data.updateSubmissionStatus(fmt.Sprintf("%d/%d", doneNum+idx+1, testCaseNum))
+		}),
 	}
-
-	slog.Info("Start executing")
-	info, err := storage.ParseInfo(data.files.InfoTomlPath())
+	if c := builtinCheckerOf(info); c != nil {
+		options = append(options, WithBuiltinChecker(*c))
+	}
+	if info.CheckerSolutionUsage {
+		options = append(options, WithSolutionUsageForChecker())
+	}
+	if compileCache != nil {
+		options = append(options, WithCompileCache(compileCache))
+	}
+	if *keepTempDir {
+		options = append(options, WithKeepTempDirOnFailure())
+	}
+	j, err := NewJudgeForProblem(data.s.Problem, info, data.files, data.lang, srcPath, options...)
 	if err != nil {
 		return err
 	}
-	testCases := info.TestCaseNames()
-	testCaseNum := len(testCases)
-	results := []CaseResult{}
-	for idx, testCaseName := range testCases {
-		if err := data.updateSubmissionStatus(fmt.Sprintf("%d/%d", idx, testCaseNum)); err != nil {
-			return err
-		}
+	defer j.Close()
 
-		inFilePath := data.files.InFilePath(testCaseName)
-		expectFilePath := data.files.OutFilePath(testCaseName)
-
-		result, err := runTestCase(sourceVolume, checkerVolume, data.lang, info.TimeLimit, inFilePath, expectFilePath)
-		if err != nil {
-			return err
-		}
-		results = append(results, result)
-
-		if err := database.SaveTestcaseResult(data.db, database.SubmissionTestcaseResult{
-			Submission: data.s.ID,
-			Testcase:   testCaseName,
-			Status:     result.Status,
-			Time:       int32(result.Time.Milliseconds()),
-			Memory:     result.Memory,
-			Stderr:     result.Stderr,
-			CheckerOut: result.CheckerOut,
-		}); err != nil {
-			return err
-		}
+	logger().Info("Start judging")
+	result, err := j.Run(TestCaseInputs(data.files, testCases))
+	if err != nil {
+		return err
+	}
+	if result.Status == "ICE" || result.Status == "CE" {
+		data.s.Status = result.Status
+		data.s.CompileError = result.CompileError()
+		return data.updateSubmission()
 	}
 
-	totalResult := AggregateResults(results)
-
-	data.s.Status = totalResult.Status
-	data.s.MaxTime = int32(totalResult.Time.Milliseconds())
-	data.s.MaxMemory = totalResult.Memory
-	return data.updateSubmission()
+	caseResults := []CaseResult{}
+	for _, r := range doneResults {
+		caseResults = append(caseResults, caseResultOf(r))
+	}
+	total := AggregateResultsWithLimits(append(caseResults, result.CaseResults...), j.TimeLimit(), j.MemoryLimitBytes())
+	data.s.Status = total.Status
+	data.s.MaxTime = int32(total.Time.Milliseconds())
+	data.s.MaxMemory = total.Memory
+	data.s.JudgedTime = time.Now()
+	if total.BarelyPassed(j.TimeLimit(), j.MemoryLimitBytes()) {
+		logger().Info("AC with small headroom", "submissionID", data.s.ID,
+			"timeHeadroom", total.TimeHeadroom, "maxTimeCase", total.MaxTimeCase,
+			"memoryHeadroom", total.MemoryHeadroom, "maxMemoryCase", total.MaxMemoryCase)
+	}
+	if err := database.RenewTask(data.db, data.taskID, data.judgeName); err != nil {
+		return err
+	}
+	if err := database.UpdateSubmissionResult(data.db, data.s.ID, data.s.Version, data.s.Status, data.s.MaxTime, data.s.MaxMemory, data.s.JudgedTime, data.s.JudgedBy); err != nil {
+		return err
+	}
+	data.s.Version++
+	return nil
 }
 
 func (data *SubmissionTaskData) updateSubmissionStatus(status string) error {
 	data.s.Status = status
-	if err := database.TouchTask(data.db, data.taskID); err != nil {
+	if err := database.RenewTask(data.db, data.taskID, data.judgeName); err != nil {
 		return err
 	}
-	if err := database.UpdateSubmissionStatus(data.db, data.s.ID, status); err != nil {
+	if err := database.UpdateSubmissionStatus(data.db, data.s.ID, data.s.Version, status); err != nil {
 		return err
 	}
+	data.s.Version++
 	return nil
 }
 
 func (data *SubmissionTaskData) updateSubmission() error {
-	if err := database.TouchTask(data.db, data.taskID); err != nil {
+	if err := database.RenewTask(data.db, data.taskID, data.judgeName); err != nil {
 		return err
 	}
 	if err := database.UpdateSubmission(data.db, data.s); err != nil {
 		return err
 	}
+	data.s.Version++
 	return nil
 }
 
-func (data *SubmissionTaskData) compileSource() (Volume, TaskResult, error) {
-	// write source to tempfile
+// writeSource writes the source to a file in a new temporary directory, which should be removed by the caller
+func (data *SubmissionTaskData) writeSource() (string, string, error) {
 	sourceDir, err := os.MkdirTemp("", "source")
 	if err != nil {
-		return Volume{}, TaskResult{}, err
+		return "", "", err
 	}
-	defer os.RemoveAll(sourceDir)
+	srcPath := path.Join(sourceDir, data.lang.Source)
+	if err := os.WriteFile(srcPath, []byte(data.source), 0644); err != nil {
+		os.RemoveAll(sourceDir)
+		return "", "", err
+	}
+	return sourceDir, srcPath, nil
+}
 
-	sourceFile, err := os.Create(path.Join(sourceDir, data.lang.Source))
-	if err != nil {
-		return Volume{}, TaskResult{}, err
+// RemainingTestCases returns the cases without results in the order of cases, e.g. to resume an interrupted judge
+func RemainingTestCases(cases []string, results []database.SubmissionTestcaseResult) []string {
+	done := map[string]bool{}
+	for _, r := range results {
+		done[r.Testcase] = true
 	}
-	if _, err := sourceFile.WriteString(data.s.Source); err != nil {
-		return Volume{}, TaskResult{}, err
+	remaining := []string{}
+	for _, c := range cases {
+		if !done[c] {
+			remaining = append(remaining, c)
+		}
 	}
-	sourceFile.Close()
+	return remaining
+}
+
+// caseResultOf returns the result of the case saved by WithCaseResultCallback
+func caseResultOf(r database.SubmissionTestcaseResult) CaseResult {
+	return CaseResult{
+		CaseName:   r.Testcase,
+		Status:     r.Status,
+		Time:       time.Duration(r.Time) * time.Millisecond,
+		Memory:     r.Memory,
+		Stderr:     r.Stderr,
+		CheckerOut: r.CheckerOut,
+	}
+}
 
-	return compile(data.files, sourceFile.Name(), data.lang)
+type AggregateResult struct {
+	CaseResult
+	// names of the first case with the max time / memory
+	MaxTimeCase   string
+	MaxMemoryCase string
+	// the min headroom (limit - peak) of the cases, set by AggregateResultsWithLimits.
+	// They are negative if the limit is exceeded, and MemoryHeadroom is meaningless if Memory is -1.
+	TimeHeadroom   time.Duration
+	MemoryHeadroom int64
 }
 
-func AggregateResults(results []CaseResult) CaseResult {
-	ans := CaseResult{
-		Status: "AC",
-		Time:   0,
-		Memory: -1,
+// AggregateResultsWithLimits aggregates results like AggregateResults with the headroom to the limits,
+// e.g. to find AC submissions which barely passed
+func AggregateResultsWithLimits(results []CaseResult, timeLimit time.Duration, memoryLimitBytes int64) AggregateResult {
+	ans := AggregateResults(results)
+	// the case with the min headroom is the case with the max time / memory
+	ans.TimeHeadroom = timeLimit - ans.Time
+	ans.MemoryHeadroom = memoryLimitBytes - ans.Memory
+	return ans
+}
+
+// SMALL_HEADROOM_RATIO is the ratio of the headroom to the limit, AC with less headroom is barely passed
+const SMALL_HEADROOM_RATIO = 0.1
+
+// BarelyPassed returns whether the result is AC with less than SMALL_HEADROOM_RATIO of the limits as the headroom.
+// The limits must be the ones passed to AggregateResultsWithLimits.
+func (r AggregateResult) BarelyPassed(timeLimit time.Duration, memoryLimitBytes int64) bool {
+	if r.Status != "AC" {
+		return false
+	}
+	if float64(r.TimeHeadroom) < float64(timeLimit)*SMALL_HEADROOM_RATIO {
+		return true
 	}
+	return r.Memory != -1 && float64(r.MemoryHeadroom) < float64(memoryLimitBytes)*SMALL_HEADROOM_RATIO
+}
+
+func AggregateResults(results []CaseResult) AggregateResult {
+	ans := AggregateResult{
+		CaseResult: CaseResult{
+			Status: "AC",
+			Time:   0,
+			Memory: -1,
+		},
+	}
+	judged := 0
 	for _, res := range results {
+		if res.Status == "Skipped" {
+			continue
+		}
 		if res.Status != "AC" {
 			ans.Status = res.Status
 		}
-		if ans.Time < res.Time {
+		if judged == 0 || ans.Time < res.Time {
 			ans.Time = res.Time
+			ans.MaxTimeCase = res.CaseName
 		}
-		if ans.Memory < res.Memory {
+		if judged == 0 || ans.Memory < res.Memory {
 			ans.Memory = res.Memory
+			ans.MaxMemoryCase = res.CaseName
 		}
+		judged++
 	}
 	return ans
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+const (
+	// bytes read from each output to find the first difference
+	MAX_DIFF_READ_LENGTH = 1 << 20
+	// tokens kept before and after the first difference
+	DIFF_CONTEXT_TOKENS   = 3
+	MAX_DIFF_TOKEN_LENGTH = 64
+)
+
+// TokenDiff is the first different token of the expected and actual outputs separated by whitespaces.
+// Expected or Actual is empty if the output ends before the token.
+type TokenDiff struct {
+	Index         int      `json:"index"` // 0-indexed
+	Expected      string   `json:"expected"`
+	Actual        string   `json:"actual"`
+	Before        []string `json:"before"` // common tokens before the difference
+	ExpectedAfter []string `json:"expected_after"`
+	ActualAfter   []string `json:"actual_after"`
+}
+
+// firstTokenDiff returns the first different token of the files, or nil if they have the same tokens.
+// Only the first MAX_DIFF_READ_LENGTH bytes are compared, and all tokens in the diff are stripped to MAX_DIFF_TOKEN_LENGTH bytes.
+func firstTokenDiff(expectFilePath, actualFilePath string) (*TokenDiff, error) {
+	expectFile, err := os.Open(expectFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer expectFile.Close()
+	actualFile, err := os.Open(actualFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer actualFile.Close()
+
+	expect := newTokenScanner(expectFile)
+	actual := newTokenScanner(actualFile)
+
+	before := []string{}
+	for idx := 0; ; idx++ {
+		e, eok := expect.next()
+		a, aok := actual.next()
+		if err := expect.err(); err != nil {
+			return nil, err
+		}
+		if err := actual.err(); err != nil {
+			return nil, err
+		}
+		if !eok && !aok {
+			return nil, nil
+		}
+		if eok == aok && e == a {
+			before = append(before, stripToken(e))
+			if len(before) > DIFF_CONTEXT_TOKENS {
+				before = before[1:]
+			}
+			continue
+		}
+
+		diff := &TokenDiff{
+			Index:         idx,
+			Expected:      stripToken(e),
+			Actual:        stripToken(a),
+			Before:        before,
+			ExpectedAfter: expect.take(DIFF_CONTEXT_TOKENS),
+			ActualAfter:   actual.take(DIFF_CONTEXT_TOKENS),
+		}
+		if err := expect.err(); err != nil {
+			return nil, err
+		}
+		if err := actual.err(); err != nil {
+			return nil, err
+		}
+		return diff, nil
+	}
+}
+
+type tokenScanner struct {
+	scanner *bufio.Scanner
+}
+
+func newTokenScanner(r io.Reader) tokenScanner {
+	scanner := bufio.NewScanner(io.LimitReader(r, MAX_DIFF_READ_LENGTH))
+	// a token can be as long as the whole read length
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_DIFF_READ_LENGTH+1)
+	scanner.Split(bufio.ScanWords)
+	return tokenScanner{scanner: scanner}
+}
+
+func (s tokenScanner) next() (string, bool) {
+	if !s.scanner.Scan() {
+		return "", false
+	}
+	return s.scanner.Text(), true
+}
+
+// take returns at most n next tokens
+func (s tokenScanner) take(n int) []string {
+	tokens := []string{}
+	for len(tokens) < n {
+		token, ok := s.next()
+		if !ok {
+			break
+		}
+		tokens = append(tokens, stripToken(token))
+	}
+	return tokens
+}
+
+func (s tokenScanner) err() error {
+	return s.scanner.Err()
+}
+
+func stripToken(token string) string {
+	if len(token) <= MAX_DIFF_TOKEN_LENGTH {
+		return token
+	}
+	return token[:MAX_DIFF_TOKEN_LENGTH] + STRIPPED_MESSAGE
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFirstTokenDiff(t *testing.T) {
+	for _, test := range []struct {
+		expect   string
+		actual   string
+		expected *TokenDiff
+	}{
+		{"1 2 3\n", "1  2\n3", nil},
+		{"", "", nil},
+		{
+			"1 2 3 4 5 6 7 8\n", "1 2 3 4 0 6 7 8 9\n",
+			&TokenDiff{Index: 4, Expected: "5", Actual: "0", Before: []string{"2", "3", "4"}, ExpectedAfter: []string{"6", "7", "8"}, ActualAfter: []string{"6", "7", "8"}},
+		},
+		{
+			"1 2\n", "1\n",
+			&TokenDiff{Index: 1, Expected: "2", Actual: "", Before: []string{"1"}, ExpectedAfter: []string{}, ActualAfter: []string{}},
+		},
+		{
+			"Yes\n", "No extra\n",
+			&TokenDiff{Index: 0, Expected: "Yes", Actual: "No", Before: []string{}, ExpectedAfter: []string{}, ActualAfter: []string{"extra"}},
+		},
+	} {
+		expectFile := toRealFile(bytes.NewBufferString(test.expect), "expect.out", t)
+		actualFile := toRealFile(bytes.NewBufferString(test.actual), "actual.out", t)
+		diff, err := firstTokenDiff(expectFile, actualFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(diff, test.expected) {
+			t.Fatalf("Error firstTokenDiff %q %q: %+v", test.expect, test.actual, diff)
+		}
+	}
+}
+
+func TestFirstTokenDiffLong(t *testing.T) {
+	long := strings.Repeat("a", MAX_DIFF_TOKEN_LENGTH*2)
+	expectFile := toRealFile(bytes.NewBufferString("1 "+long), "expect.out", t)
+	actualFile := toRealFile(bytes.NewBufferString("1 b"), "actual.out", t)
+	diff, err := firstTokenDiff(expectFile, actualFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil || diff.Expected != long[:MAX_DIFF_TOKEN_LENGTH]+STRIPPED_MESSAGE {
+		t.Fatal("token is not stripped", diff)
+	}
+
+	// the context tokens are also stripped
+	expectFile = toRealFile(bytes.NewBufferString(long+" 1 "+long), "expect.out", t)
+	actualFile = toRealFile(bytes.NewBufferString(long+" 2 "+long), "actual.out", t)
+	diff, err = firstTokenDiff(expectFile, actualFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := []string{long[:MAX_DIFF_TOKEN_LENGTH] + STRIPPED_MESSAGE}
+	if diff == nil || !reflect.DeepEqual(diff.Before, stripped) || !reflect.DeepEqual(diff.ExpectedAfter, stripped) || !reflect.DeepEqual(diff.ActualAfter, stripped) {
+		t.Fatal("context tokens are not stripped", diff)
+	}
+
+	// differences after MAX_DIFF_READ_LENGTH are ignored
+	same := strings.Repeat("1 ", MAX_DIFF_READ_LENGTH/2)
+	expectFile = toRealFile(bytes.NewBufferString(same+"2"), "expect.out", t)
+	actualFile = toRealFile(bytes.NewBufferString(same+"3"), "actual.out", t)
+	diff, err = firstTokenDiff(expectFile, actualFile)
+	if err != nil || diff != nil {
+		t.Fatal("Error firstTokenDiff", diff, err)
+	}
+}
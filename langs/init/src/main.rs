@@ -14,7 +14,7 @@ fn main() {
     }
 
     let stdin = open(args[1].as_str(), OFlag::O_RDONLY, Mode::empty()).expect("failed to open input file");
-    let stdout = open(args[2].as_str(), OFlag::O_WRONLY | OFlag::O_CREAT, Mode::empty()).expect("failed to open output file");
+    let stdout = open(args[2].as_str(), OFlag::O_WRONLY | OFlag::O_CREAT | OFlag::O_TRUNC | OFlag::O_NOFOLLOW, Mode::empty()).expect("failed to open output file");
 
     dup2(stdin, 0).expect("failed to dup2 stdin");
     dup2(stdout, 1).expect("failed to dup2 stdout");
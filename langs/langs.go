@@ -2,9 +2,12 @@ package langs
 
 import (
 	_ "embed"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"slices"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 )
@@ -20,7 +23,24 @@ type Lang struct {
 	AdditionalFiles []string `toml:"additional_files"`
 }
 
+// langSet is replaced as a whole by LoadLangs, so readers never see languages of different files
+type langSet struct {
+	langs         []Lang
+	modelSolution Lang
+}
+
+var current atomic.Pointer[langSet]
+
+// LANGS is the languages at the last load, it is kept for compatibility.
+//
+// Deprecated: LANGS is replaced by LoadLangs without synchronization, use Langs instead.
 var LANGS []Lang
+
+// LANG_MODEL_SOLUTION is the language of model solutions at the last load, it is kept for compatibility.
+//
+// Deprecated: LANG_MODEL_SOLUTION is replaced by LoadLangs without synchronization, use ModelSolution instead.
+var LANG_MODEL_SOLUTION Lang
+
 var LANG_CHECKER = Lang{
 	ID:        "checker",
 	Source:    "checker.cpp",
@@ -42,35 +62,66 @@ var LANG_GENERATOR = Lang{
 	Compile:   []string{"g++", "-O2", "-std=c++17", "-march=native", "-o", "generator", "generator.cpp"},
 	Exec:      []string{"./generator", "0"},
 }
-var LANG_MODEL_SOLUTION Lang
 
 //go:embed langs.toml
 var langToml string
 
 func init() {
+	if err := loadLangs(langToml); err != nil {
+		slog.Error("failed to load embedded langs.toml", slog.Any("err", err))
+		os.Exit(1)
+	}
+}
+
+// LoadLangs replaces the languages by the ones in the toml file of path, they are not changed if an error is returned.
+// It is safe to call while the languages are used, e.g. to add languages without restarting judges.
+func LoadLangs(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return loadLangs(string(data))
+}
+
+func loadLangs(langsToml string) error {
 	var data struct {
 		Langs []Lang `toml:"langs"`
 	}
-	if _, err := toml.Decode(langToml, &data); err != nil {
-		slog.Error("toml decode failed", slog.Any("err", err))
-		os.Exit(1)
+	if _, err := toml.Decode(langsToml, &data); err != nil {
+		return fmt.Errorf("toml decode failed: %w", err)
 	}
-	LANGS = data.Langs
-
-	if lang, ok := GetLang("cpp"); !ok {
-		slog.Error("cpp is not found in langs")
-		os.Exit(1)
-	} else {
-		LANG_MODEL_SOLUTION = lang
+	idx := slices.IndexFunc(data.Langs, func(lang Lang) bool {
+		return lang.ID == "cpp"
+	})
+	if idx == -1 {
+		return errors.New("cpp is not found in langs")
 	}
+	current.Store(&langSet{
+		langs:         data.Langs,
+		modelSolution: data.Langs[idx],
+	})
+	LANGS = slices.Clone(data.Langs)
+	LANG_MODEL_SOLUTION = data.Langs[idx]
+	return nil
+}
+
+// Langs returns all languages for submissions
+func Langs() []Lang {
+	return slices.Clone(current.Load().langs)
+}
+
+// ModelSolution returns the language of model solutions, it is the same as cpp
+func ModelSolution() Lang {
+	return current.Load().modelSolution
 }
 
 func GetLang(id string) (Lang, bool) {
-	if idx := slices.IndexFunc(LANGS, func(lang Lang) bool {
+	langs := current.Load().langs
+	if idx := slices.IndexFunc(langs, func(lang Lang) bool {
 		return lang.ID == id
 	}); idx == -1 {
 		return Lang{}, false
 	} else {
-		return LANGS[idx], true
+		return langs[idx], true
 	}
 }
@@ -0,0 +1,82 @@
+package langs
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadLangs(t *testing.T) {
+	defaultLangs := current.Load()
+	t.Cleanup(func() {
+		current.Store(defaultLangs)
+	})
+
+	tomlPath := path.Join(t.TempDir(), "langs.toml")
+	if err := os.WriteFile(tomlPath, []byte(`
+[[langs]]
+id = "cpp"
+name = "C++"
+source = "main.cpp"
+compile = ["g++", "main.cpp"]
+exec = ["./a.out"]
+image_name = "gcc"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadLangs(tomlPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(Langs()) != 1 || ModelSolution().Source != "main.cpp" {
+		t.Fatal("langs are not loaded", Langs(), ModelSolution())
+	}
+	if _, ok := GetLang("rust"); ok {
+		t.Fatal("rust is found in loaded langs")
+	}
+
+	for _, content := range []string{
+		"invalid toml",
+		"[[langs]]\nid = \"rust\"\n",
+	} {
+		if err := os.WriteFile(tomlPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadLangs(tomlPath); err == nil {
+			t.Fatal("invalid langs.toml is loaded", content)
+		}
+		if len(Langs()) != 1 {
+			t.Fatal("langs are changed by failed load", Langs())
+		}
+	}
+
+	if err := LoadLangs(path.Join(t.TempDir(), "not_found.toml")); err == nil {
+		t.Fatal("missing langs.toml is loaded")
+	}
+}
+
+func TestLoadLangsConcurrently(t *testing.T) {
+	defaultLangs := current.Load()
+	t.Cleanup(func() {
+		current.Store(defaultLangs)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := loadLangs(langToml); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, ok := GetLang("cpp"); !ok {
+			t.Fatal("cpp is not found while reloading")
+		}
+		if ModelSolution().ID != "cpp" {
+			t.Fatal("model solution is not cpp while reloading")
+		}
+	}
+	<-done
+}
@@ -110,6 +110,10 @@ func (p ProblemFiles) CheckerPath() string {
 	return p.PublicFilePath("checker.cpp")
 }
 
+func (p ProblemFiles) TestlibPath() string {
+	return p.PublicFilePath(path.Join("common", "testlib.h"))
+}
+
 func (p ProblemFiles) SolutionPath() string {
 	return p.PublicFilePath(path.Join("sol", "correct.cpp"))
 }
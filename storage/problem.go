@@ -68,6 +68,20 @@ type Info struct {
 		Name   string
 		Number int
 	}
+	// extra flags appended to the compile command of checker.cpp
+	CheckerCompileFlags []string `toml:"checker_compile_flags"`
+	// extra arguments passed to the checker after the file names, e.g. eps
+	CheckerArguments []string `toml:"checker_arguments"`
+	// stack size limit of solutions, 0 means unlimited
+	StackLimitMB int `toml:"stack_limit_mb"`
+	// memory limit of solutions, 0 means the default limit of the judge
+	MemoryLimitMB int `toml:"memory_limit_mb"`
+	// pass the time and memory used by the solution to the checker, see WithSolutionUsageForChecker of the judge
+	CheckerSolutionUsage bool `toml:"checker_solution_usage"`
+	// checker built in the judge used instead of checker.cpp, e.g. "wcmp", see BuiltinChecker of the judge
+	BuiltinChecker string  `toml:"builtin_checker"`
+	CheckerAbsEps  float64 `toml:"checker_abs_eps"`
+	CheckerRelEps  float64 `toml:"checker_rel_eps"`
 }
 
 func ParseInfo(tomlPath string) (Info, error) {
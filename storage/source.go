@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// SourceStore stores sources of submissions in the private bucket, it implements database.SourceStore
+type SourceStore struct {
+	client Client
+}
+
+func NewSourceStore(client Client) SourceStore {
+	return SourceStore{client: client}
+}
+
+func (s SourceStore) Save(id int32, source string) (string, error) {
+	key := fmt.Sprintf("v3/submissions/%d/source", id)
+	if _, err := s.client.client.PutObject(context.Background(), s.client.bucket, key, strings.NewReader(source), int64(len(source)), minio.PutObjectOptions{}); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (s SourceStore) Load(key string) (string, error) {
+	object, err := s.client.client.GetObject(context.Background(), s.client.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	source, err := io.ReadAll(object)
+	if err != nil {
+		return "", err
+	}
+	return string(source), nil
+}
+
+func (s SourceStore) Delete(key string) error {
+	return s.client.client.RemoveObject(context.Background(), s.client.bucket, key, minio.RemoveObjectOptions{})
+}